@@ -0,0 +1,556 @@
+//
+//  This file is part of go-apt-client library
+//
+//  Copyright (C) 2017  Arduino AG (http://www.arduino.cc/)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+//
+
+package apt
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// APTConfNode is a node of the apt.conf configuration tree
+type APTConfNode struct {
+	// Tag is the name of the node, empty for the items of a list
+	Tag      string
+	Value    string
+	Children []*APTConfNode
+}
+
+// child returns the child with the given tag, the comparison is
+// case-insensitive like in apt
+func (n *APTConfNode) child(tag string) *APTConfNode {
+	for _, c := range n.Children {
+		if c.Tag != "" && strings.EqualFold(c.Tag, tag) {
+			return c
+		}
+	}
+	return nil
+}
+
+// APTConf is the configuration tree of apt, read from apt.conf and the
+// apt.conf.d folder
+type APTConf struct {
+	root *APTConfNode
+}
+
+// splitAPTConfKey splits a key like "APT::Get::Assume-Yes" in its tags
+func splitAPTConfKey(key string) []string {
+	return strings.Split(strings.TrimSuffix(key, "::"), "::")
+}
+
+// Node returns the node of the key (like "APT::Get"), or nil if the key
+// is not set
+func (c *APTConf) Node(key string) *APTConfNode {
+	n := c.root
+	for _, tag := range splitAPTConfKey(key) {
+		if n = n.child(tag); n == nil {
+			return nil
+		}
+	}
+	return n
+}
+
+// node returns the node of the key, creating it if missing
+func (c *APTConf) node(key string) *APTConfNode {
+	n := c.root
+	for _, tag := range splitAPTConfKey(key) {
+		child := n.child(tag)
+		if child == nil {
+			child = &APTConfNode{Tag: tag}
+			n.Children = append(n.Children, child)
+		}
+		n = child
+	}
+	return n
+}
+
+// Exists returns true if the key is set
+func (c *APTConf) Exists(key string) bool {
+	return c.Node(key) != nil
+}
+
+// Get returns the value of the key, an empty string if it is not set
+func (c *APTConf) Get(key string) string {
+	if n := c.Node(key); n != nil {
+		return n.Value
+	}
+	return ""
+}
+
+// GetBool returns the value of the key as a boolean, def if it is not set
+// or it is not a boolean
+func (c *APTConf) GetBool(key string, def bool) bool {
+	n := c.Node(key)
+	if n == nil {
+		return def
+	}
+	if i, err := strconv.Atoi(strings.TrimSpace(n.Value)); err == nil {
+		return i != 0
+	}
+	return parseDeb822Bool(n.Value, def)
+}
+
+// GetList returns the values of the items of the list key (like
+// "DPkg::Options")
+func (c *APTConf) GetList(key string) []string {
+	res := []string{}
+	if n := c.Node(key); n != nil {
+		for _, child := range n.Children {
+			res = append(res, child.Value)
+		}
+	}
+	return res
+}
+
+// Dump returns the whole configuration, one key for each line, in the
+// same format used by "apt-config dump"
+func (c *APTConf) Dump() string {
+	var res strings.Builder
+	var dump func(n *APTConfNode, prefix string)
+	dump = func(n *APTConfNode, prefix string) {
+		for _, child := range n.Children {
+			key := prefix + child.Tag
+			fmt.Fprintf(&res, "%s %s;\n", key, strconv.Quote(child.Value))
+			dump(child, key+"::")
+		}
+	}
+	dump(c.root, "")
+	return res.String()
+}
+
+// apply applies the statements to the configuration tree
+func (c *APTConf) apply(statements []*aptConfStatement) {
+	for _, s := range statements {
+		switch s.kind {
+		case aptConfValue:
+			c.node(s.key).Value = s.value
+		case aptConfListItem:
+			n := c.node(s.key)
+			n.Children = append(n.Children, &APTConfNode{Value: s.value})
+		case aptConfScope:
+			c.node(s.key)
+		case aptConfClear:
+			tags := splitAPTConfKey(s.key)
+			parent := c.root
+			if len(tags) > 1 {
+				parent = c.Node(strings.Join(tags[:len(tags)-1], "::"))
+			}
+			if parent != nil {
+				parent.Children = slices.DeleteFunc(parent.Children, func(n *APTConfNode) bool {
+					return n.Tag != "" && strings.EqualFold(n.Tag, tags[len(tags)-1])
+				})
+			}
+		}
+	}
+}
+
+type aptConfStatementKind int
+
+const (
+	aptConfValue aptConfStatementKind = iota
+	aptConfListItem
+	aptConfScope
+	aptConfClear
+	aptConfInclude
+)
+
+// aptConfStatement is a statement of an apt.conf file
+type aptConfStatement struct {
+	kind aptConfStatementKind
+	// key is the full key, for list items the key of the list
+	key   string
+	value string
+	// start and end are the position of the statement in the file, for
+	// scopes up to the closing brace
+	start, end int
+}
+
+type aptConfToken struct {
+	// kind is 'w' for words, 's' for strings, '#' for directives or one
+	// of '{', '}' and ';'
+	kind       byte
+	text       string
+	start, end int
+}
+
+// tokenizeAPTConf splits the content of an apt.conf file in tokens,
+// skipping the comments
+func tokenizeAPTConf(data string) ([]*aptConfToken, error) {
+	res := []*aptConfToken{}
+	lineOf := func(pos int) int { return strings.Count(data[:pos], "\n") + 1 }
+	for i := 0; i < len(data); {
+		ch := data[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\r' || ch == '\n':
+			i++
+		case strings.HasPrefix(data[i:], "//"):
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(data[i:], "/*"):
+			end := strings.Index(data[i+2:], "*/")
+			if end == -1 {
+				return nil, fmt.Errorf("line %d: unterminated comment", lineOf(i))
+			}
+			i += end + 4
+		case ch == '#':
+			start := i
+			for i < len(data) && !strings.ContainsRune(" \t\r\n;", rune(data[i])) {
+				i++
+			}
+			if word := data[start:i]; word == "#clear" || word == "#include" {
+				res = append(res, &aptConfToken{kind: '#', text: word, start: start, end: i})
+				continue
+			}
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+		case ch == '"':
+			end := strings.IndexByte(data[i+1:], '"')
+			if end == -1 {
+				return nil, fmt.Errorf("line %d: unterminated string", lineOf(i))
+			}
+			res = append(res, &aptConfToken{kind: 's', text: data[i+1 : i+1+end], start: i, end: i + end + 2})
+			i += end + 2
+		case ch == '{' || ch == '}' || ch == ';':
+			res = append(res, &aptConfToken{kind: ch, start: i, end: i + 1})
+			i++
+		default:
+			start := i
+			for i < len(data) && !strings.ContainsRune(" \t\r\n{};\"", rune(data[i])) && !strings.HasPrefix(data[i:], "//") {
+				i++
+			}
+			res = append(res, &aptConfToken{kind: 'w', text: data[start:i], start: start, end: i})
+		}
+	}
+	return res, nil
+}
+
+// parseAPTConfStatements parses the content of an apt.conf file
+func parseAPTConfStatements(data string) ([]*aptConfStatement, error) {
+	tokens, err := tokenizeAPTConf(data)
+	if err != nil {
+		return nil, err
+	}
+	lineOf := func(pos int) int { return strings.Count(data[:pos], "\n") + 1 }
+	syntaxError := func(t *aptConfToken) error {
+		return fmt.Errorf("line %d: syntax error", lineOf(t.start))
+	}
+	kind := func(i int) byte {
+		if i < len(tokens) {
+			return tokens[i].kind
+		}
+		return 0
+	}
+
+	res := []*aptConfStatement{}
+	scopes := []string{}
+	opened := []*aptConfStatement{}
+	fullKey := func(key string) string {
+		if key == "" {
+			return strings.Join(scopes, "::")
+		}
+		return strings.Join(append(slices.Clone(scopes), key), "::")
+	}
+	for i := 0; i < len(tokens); {
+		t := tokens[i]
+		switch {
+		case t.kind == ';':
+			i++
+		case t.kind == '}':
+			if len(scopes) == 0 {
+				return nil, syntaxError(t)
+			}
+			// The span of the scope statement includes the whole block
+			scope := opened[len(opened)-1]
+			scope.end = t.end
+			if kind(i+1) == ';' {
+				scope.end = tokens[i+1].end
+				i++
+			}
+			scopes = scopes[:len(scopes)-1]
+			opened = opened[:len(opened)-1]
+			i++
+		case t.kind == '#':
+			if (kind(i+1) != 'w' && kind(i+1) != 's') || kind(i+2) != ';' {
+				return nil, syntaxError(t)
+			}
+			s := &aptConfStatement{kind: aptConfClear, start: t.start, end: tokens[i+2].end}
+			if t.text == "#include" {
+				s.kind = aptConfInclude
+				s.value = tokens[i+1].text
+			} else {
+				s.key = fullKey(tokens[i+1].text)
+			}
+			res = append(res, s)
+			i += 3
+		case t.kind == 's' && kind(i+1) == ';' && len(scopes) > 0:
+			// An item of the list of the enclosing scope
+			res = append(res, &aptConfStatement{kind: aptConfListItem, key: fullKey(""), value: t.text, start: t.start, end: tokens[i+1].end})
+			i += 2
+		case (t.kind == 'w' || t.kind == 's') && kind(i+1) == '{':
+			scopes = append(scopes, strings.TrimSuffix(t.text, "::"))
+			res = append(res, &aptConfStatement{kind: aptConfScope, key: fullKey(""), start: t.start, end: tokens[i+1].end})
+			opened = append(opened, res[len(res)-1])
+			i += 2
+		case t.kind == 'w' && (kind(i+1) == 's' || kind(i+1) == 'w') && kind(i+2) == ';':
+			s := &aptConfStatement{kind: aptConfValue, key: fullKey(t.text), value: tokens[i+1].text, start: t.start, end: tokens[i+2].end}
+			if strings.HasSuffix(t.text, "::") {
+				s.kind = aptConfListItem
+				s.key = strings.TrimSuffix(s.key, "::")
+			}
+			res = append(res, s)
+			i += 3
+		case t.kind == 'w' && kind(i+1) == ';':
+			res = append(res, &aptConfStatement{kind: aptConfValue, key: fullKey(t.text), start: t.start, end: tokens[i+1].end})
+			i += 2
+		default:
+			return nil, syntaxError(t)
+		}
+	}
+	if len(scopes) > 0 {
+		return nil, fmt.Errorf("line %d: missing '}'", lineOf(len(data)))
+	}
+	return res, nil
+}
+
+var aptConfFileNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// isAPTConfFileName returns true if apt reads the file of "apt.conf.d"
+// with the given name: only letters, digits, '_', '-' and '.' are
+// allowed, and the extension must be missing or ".conf"
+func isAPTConfFileName(name string) bool {
+	return aptConfFileNameRegexp.MatchString(name) && (!strings.Contains(name, ".") || strings.HasSuffix(name, ".conf"))
+}
+
+// aptConfFiles returns the files in the folder read by apt, sorted by
+// name
+func aptConfFiles(folder string, c *config) ([]string, error) {
+	list, err := fs.ReadDir(c.fs, folder)
+	if err != nil {
+		return nil, err
+	}
+	res := []string{}
+	for _, l := range list {
+		if !l.IsDir() && isAPTConfFileName(l.Name()) {
+			res = append(res, filepath.Join(folder, l.Name()))
+		}
+	}
+	return res, nil
+}
+
+// maxAPTConfIncludes limits the nesting of the #include directives
+const maxAPTConfIncludes = 100
+
+// readAPTConfFile parses the file, or all the files of the folder, and
+// applies it to the configuration tree
+func (c *APTConf) readAPTConfFile(path string, cfg *config, depth int) error {
+	if depth > maxAPTConfIncludes {
+		return fmt.Errorf("too many nested includes")
+	}
+	if info, err := fs.Stat(cfg.fs, path); err == nil && info.IsDir() {
+		files, err := aptConfFiles(path, cfg)
+		if err != nil {
+			return fmt.Errorf("reading %s folder: %s", path, err)
+		}
+		for _, file := range files {
+			if err := c.readAPTConfFile(file, cfg, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	data, err := fs.ReadFile(cfg.fs, path)
+	if err != nil {
+		return fmt.Errorf("reading %s: %s", path, err)
+	}
+	statements, err := parseAPTConfStatements(string(data))
+	if err != nil {
+		return fmt.Errorf("parsing %s: %s", path, err)
+	}
+	for _, s := range statements {
+		if s.kind != aptConfInclude {
+			c.apply([]*aptConfStatement{s})
+			continue
+		}
+		include := s.value
+		if filepath.IsAbs(include) {
+			include = cfg.fsPath(include)
+		} else {
+			include = filepath.Join(filepath.Dir(path), include)
+		}
+		if err := c.readAPTConfFile(include, cfg, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParseAPTConf reads the apt configuration in the specified APT config
+// folder (usually /etc/apt) in the same order used by apt: the files in
+// the "apt.conf.d" folder, sorted by name, and then the "apt.conf" file.
+func ParseAPTConf(configFolderPath string, opts ...Option) (*APTConf, error) {
+	c := newConfig(opts)
+	res := &APTConf{root: &APTConfNode{}}
+	folder := filepath.Join(configFolderPath, "apt.conf.d")
+	if _, err := fs.Stat(c.fs, folder); err == nil {
+		if err := res.readAPTConfFile(folder, c, 0); err != nil {
+			return nil, err
+		}
+	}
+	main := filepath.Join(configFolderPath, "apt.conf")
+	if _, err := fs.Stat(c.fs, main); err == nil {
+		if err := res.readAPTConfFile(main, c, 0); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// SetAPTConfValue sets the key (like "APT::Install-Recommends") to value
+// in the snippet file with the given name in the "apt.conf.d" subfolder
+// of the specified APT config folder (usually /etc/apt). The previous
+// settings of the key in the snippet are replaced, the snippet is created
+// if it doesn't exist.
+func SetAPTConfValue(snippet, key, value string, configFolderPath string, opts ...Option) error {
+	if strings.ContainsAny(value, "\"\r\n") {
+		return fmt.Errorf("invalid value '%s'", value)
+	}
+	return changeAPTConfSnippet(snippet, key, fmt.Sprintf("%s \"%s\";\n", key, value), configFolderPath, newConfig(opts))
+}
+
+// SetAPTConfList sets the key (like "DPkg::Options") to the list of
+// values in the snippet file, like SetAPTConfValue
+func SetAPTConfList(snippet, key string, values []string, configFolderPath string, opts ...Option) error {
+	items := ""
+	for _, value := range values {
+		if strings.ContainsAny(value, "\"\r\n") {
+			return fmt.Errorf("invalid value '%s'", value)
+		}
+		items += fmt.Sprintf(" \"%s\";", value)
+	}
+	return changeAPTConfSnippet(snippet, key, fmt.Sprintf("%s {%s };\n", key, items), configFolderPath, newConfig(opts))
+}
+
+// UnsetAPTConf removes the settings of the key from the snippet file
+// with the given name in the "apt.conf.d" subfolder of the specified APT
+// config folder (usually /etc/apt). The snippet is deleted if no settings
+// are left.
+func UnsetAPTConf(snippet, key string, configFolderPath string, opts ...Option) error {
+	return changeAPTConfSnippet(snippet, key, "", configFolderPath, newConfig(opts))
+}
+
+var aptConfKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9_.+/-]+(::[A-Za-z0-9_.+/-]+)*$`)
+
+// changeAPTConfSnippet removes the settings of the key from the snippet
+// and appends the given text
+func changeAPTConfSnippet(snippet, key, text string, configFolderPath string, c *config) error {
+	if !isAPTConfFileName(snippet) {
+		return fmt.Errorf("invalid snippet name '%s'", snippet)
+	}
+	if !aptConfKeyRegexp.MatchString(key) {
+		return fmt.Errorf("invalid key '%s'", key)
+	}
+	unlock, err := c.lock(configFolderPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	fsys, err := c.writableFS()
+	if err != nil {
+		return err
+	}
+	folder := filepath.Join(configFolderPath, "apt.conf.d")
+	if err := fsys.MkdirAll(folder, 0755); err != nil {
+		return fmt.Errorf("creating apt.conf.d folder: %s", err)
+	}
+	path := filepath.Join(folder, snippet)
+	data, err := fs.ReadFile(fsys, path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("reading %s: %s", path, err)
+	}
+	statements, err := parseAPTConfStatements(string(data))
+	if err != nil {
+		return fmt.Errorf("parsing %s: %s", path, err)
+	}
+
+	// Remove the settings of the key, and of its children, including the
+	// whole scopes of the key
+	content := string(data)
+	matches := func(k string) bool {
+		return strings.EqualFold(k, key) || strings.HasPrefix(strings.ToLower(k), strings.ToLower(key)+"::")
+	}
+	left := 0
+	removed := []*aptConfStatement{}
+	for _, s := range statements {
+		if len(removed) > 0 && s.start < removed[len(removed)-1].end {
+			continue
+		}
+		if s.kind == aptConfInclude || s.kind == aptConfClear || !matches(s.key) {
+			if s.kind != aptConfScope {
+				left++
+			}
+			continue
+		}
+		removed = append(removed, s)
+	}
+	for i := len(removed) - 1; i >= 0; i-- {
+		content = replaceSpan(content, removed[i].start, removed[i].end, "")
+	}
+
+	change := &fileChange{path: path}
+	if text != "" {
+		if content != "" && !strings.HasSuffix(content, "\n") {
+			content += "\n"
+		}
+		content += text
+	} else if left == 0 {
+		change.deleted = true
+	}
+	change.content = []byte(content)
+	if _, err := fs.Stat(fsys, path); os.IsNotExist(err) && change.deleted {
+		return nil
+	}
+	if err := writeSourceFiles(fsys, []*fileChange{change}, c.backups); err != nil {
+		return fmt.Errorf("writing of new config: %s", err)
+	}
+	return nil
+}
+
+// replaceSpan replaces the text between start and end with text. If text
+// is empty and nothing else is left on the line, the whole line is
+// removed.
+func replaceSpan(content string, start, end int, text string) string {
+	if text == "" {
+		lineStart := strings.LastIndexByte(content[:start], '\n') + 1
+		lineEnd := len(content)
+		if idx := strings.IndexByte(content[end:], '\n'); idx != -1 {
+			lineEnd = end + idx + 1
+		}
+		if strings.TrimSpace(content[lineStart:start]) == "" && strings.TrimSpace(content[end:lineEnd]) == "" {
+			start, end = lineStart, lineEnd
+		}
+	}
+	return content[:start] + text + content[end:]
+}
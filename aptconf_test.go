@@ -0,0 +1,126 @@
+//
+//  This file is part of go-apt-client library
+//
+//  Copyright (C) 2017  Arduino AG (http://www.arduino.cc/)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+//
+
+package apt
+
+import (
+	"io/fs"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAPTConf(t *testing.T) {
+	fsys := NewMemFS(map[string]string{
+		"etc/apt/apt.conf": "// Main config\n" +
+			"APT::Install-Recommends \"false\";\n" +
+			"#include \"extra/proxy.conf\";\n",
+		"etc/apt/extra/proxy.conf": "Acquire::http::Proxy \"http://proxy:3128\";\n",
+		"etc/apt/apt.conf.d/10retries": "Acquire { Retries \"3\"; };\n" +
+			"/* Options passed\n   to dpkg */\n" +
+			"DPkg::Options { \"--force-confdef\"; \"--force-confold\"; };\n" +
+			"# a comment\n" +
+			"APT::Install-Recommends \"true\";\n",
+		"etc/apt/apt.conf.d/20clear.conf": "#clear DPkg::Options;\n" +
+			"DPkg::Options:: \"--force-confnew\";\n" +
+			"apt::get { assume-yes \"1\"; };\n",
+		"etc/apt/apt.conf.d/30ignored.txt": "APT::Ignored \"true\";\n",
+		"etc/apt/apt.conf.d/99broken~":     "APT::Broken \"true\";\n",
+	})
+	conf, err := ParseAPTConf("etc/apt", WithFS(fsys))
+	require.NoError(t, err)
+
+	require.Equal(t, "3", conf.Get("Acquire::Retries"))
+	require.Equal(t, "http://proxy:3128", conf.Get("acquire::HTTP::proxy"))
+	require.Equal(t, []string{"--force-confnew"}, conf.GetList("DPkg::Options"))
+	// apt.conf is read after apt.conf.d
+	require.False(t, conf.GetBool("APT::Install-Recommends", true))
+	require.True(t, conf.GetBool("APT::Get::Assume-Yes", false))
+	require.True(t, conf.GetBool("APT::Missing", true))
+	require.True(t, conf.Exists("APT::Get"))
+	require.False(t, conf.Exists("APT::Ignored"))
+	require.False(t, conf.Exists("APT::Broken"))
+	require.Equal(t, "", conf.Get("APT::Missing"))
+	require.Empty(t, conf.GetList("APT::Missing"))
+
+	require.Equal(t, "Acquire \"\";\n"+
+		"Acquire::Retries \"3\";\n"+
+		"Acquire::http \"\";\n"+
+		"Acquire::http::Proxy \"http://proxy:3128\";\n"+
+		"DPkg \"\";\n"+
+		"DPkg::Options \"\";\n"+
+		"DPkg::Options:: \"--force-confnew\";\n"+
+		"APT \"\";\n"+
+		"APT::Install-Recommends \"false\";\n"+
+		"APT::get \"\";\n"+
+		"APT::get::assume-yes \"1\";\n", conf.Dump())
+
+	// Syntax errors report the file and the line
+	fsys = NewMemFS(map[string]string{"etc/apt/apt.conf": "APT::A \"1\";\nAPT::B \"2\"\n"})
+	_, err = ParseAPTConf("etc/apt", WithFS(fsys))
+	require.EqualError(t, err, "parsing etc/apt/apt.conf: line 2: syntax error")
+	fsys = NewMemFS(map[string]string{"etc/apt/apt.conf": "APT { A \"1\";\n"})
+	_, err = ParseAPTConf("etc/apt", WithFS(fsys))
+	require.Error(t, err)
+	fsys = NewMemFS(map[string]string{"etc/apt/apt.conf": "#include \"apt.conf\";\n"})
+	_, err = ParseAPTConf("etc/apt", WithFS(fsys))
+	require.Error(t, err)
+}
+
+func TestSetAPTConf(t *testing.T) {
+	fsys := NewMemFS(map[string]string{
+		"etc/apt/sources.list": "",
+		"etc/apt/apt.conf.d/90local": "// Local settings\n" +
+			"APT::Install-Recommends \"true\";\n" +
+			"DPkg::Options { \"--force-confdef\"; };\n" +
+			"DPkg::Options:: \"--force-confold\";\n",
+	})
+	opts := []Option{WithFS(fsys), WithBackups(0)}
+	read := func(name string) string {
+		data, err := fs.ReadFile(fsys, "etc/apt/apt.conf.d/"+name)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	require.NoError(t, SetAPTConfValue("90local", "APT::Install-Recommends", "false", "etc/apt", opts...))
+	require.NoError(t, SetAPTConfList("90local", "DPkg::Options", []string{"--force-confnew"}, "etc/apt", opts...))
+	require.Equal(t, "// Local settings\n"+
+		"APT::Install-Recommends \"false\";\n"+
+		"DPkg::Options { \"--force-confnew\"; };\n", read("90local"))
+
+	require.NoError(t, SetAPTConfValue("80proxy", "Acquire::http::Proxy", "http://proxy:3128", "etc/apt", opts...))
+	require.Equal(t, "Acquire::http::Proxy \"http://proxy:3128\";\n", read("80proxy"))
+
+	conf, err := ParseAPTConf("etc/apt", opts...)
+	require.NoError(t, err)
+	require.Equal(t, "http://proxy:3128", conf.Get("Acquire::http::Proxy"))
+	require.Equal(t, []string{"--force-confnew"}, conf.GetList("DPkg::Options"))
+	require.False(t, conf.GetBool("APT::Install-Recommends", true))
+
+	// Unsetting a parent key removes the children too
+	require.NoError(t, UnsetAPTConf("80proxy", "Acquire", "etc/apt", opts...))
+	_, err = fs.Stat(fsys, "etc/apt/apt.conf.d/80proxy")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	require.NoError(t, UnsetAPTConf("90local", "DPkg::Options", "etc/apt", opts...))
+	require.Equal(t, "// Local settings\nAPT::Install-Recommends \"false\";\n", read("90local"))
+	require.NoError(t, UnsetAPTConf("missing", "APT", "etc/apt", opts...))
+
+	require.EqualError(t, SetAPTConfValue("../apt.conf", "APT::X", "1", "etc/apt", opts...), "invalid snippet name '../apt.conf'")
+	require.Error(t, SetAPTConfValue("90local", "APT::X", "a\"b", "etc/apt", opts...))
+	require.Error(t, SetAPTConfValue("90local", "APT:: X", "1", "etc/apt", opts...))
+}
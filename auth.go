@@ -0,0 +1,449 @@
+//
+//  This file is part of go-apt-client library
+//
+//  Copyright (C) 2017  Arduino AG (http://www.arduino.cc/)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+//
+
+package apt
+
+import (
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// AuthEntry is an entry of the auth.conf files, with the credentials
+// used by apt to access a private repository
+type AuthEntry struct {
+	// Machine is the host of the repository, optionally followed by the
+	// port and by a path prefix (like "example.com/debian"). Without an
+	// explicit scheme (like "http://example.com") the entry is used only
+	// for https and tor+https URIs.
+	Machine  string
+	Login    string
+	Password string
+
+	// File is the path of the auth file that defines the entry
+	File string `json:",omitempty"`
+	// Line is the line number (starting from 1) of the entry in File
+	Line int `json:",omitempty"`
+}
+
+// String returns the entry in the auth.conf format, with the password
+// redacted
+func (e *AuthEntry) String() string {
+	res := "machine " + e.Machine + " login " + e.Login
+	if e.Password != "" {
+		res += " password xxxxx"
+	}
+	return res
+}
+
+// format returns the entry in the auth.conf format
+func (e *AuthEntry) format() string {
+	res := "machine " + e.Machine + " login " + e.Login
+	if e.Password != "" {
+		res += " password " + e.Password
+	}
+	return res
+}
+
+// validate checks that the entry can be written in an auth file
+func (e *AuthEntry) validate() error {
+	if e.Machine == "" {
+		return fmt.Errorf("missing machine")
+	}
+	if e.Login == "" {
+		return fmt.Errorf("missing login")
+	}
+	for _, value := range []string{e.Machine, e.Login, e.Password} {
+		if strings.ContainsAny(value, " \t\r\n") || strings.HasPrefix(value, "#") {
+			return fmt.Errorf("the credentials contain invalid characters")
+		}
+	}
+	return nil
+}
+
+// Matches returns true if apt uses the entry to access the URI
+func (e *AuthEntry) Matches(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	machine := e.Machine
+	if scheme, rest, ok := strings.Cut(machine, "://"); ok {
+		if !strings.EqualFold(scheme, u.Scheme) {
+			return false
+		}
+		machine = rest
+	} else if u.Scheme != "https" && u.Scheme != "tor+https" {
+		return false
+	}
+	host, prefix, _ := strings.Cut(machine, "/")
+	m, err := url.Parse("//" + host)
+	if err != nil {
+		return false
+	}
+	if !strings.EqualFold(m.Hostname(), u.Hostname()) || (m.Port() != "" && m.Port() != u.Port()) {
+		return false
+	}
+	return strings.HasPrefix(strings.TrimPrefix(u.Path, "/"), prefix)
+}
+
+// splitCredentials returns the URI without the credentials, and the auth
+// entry with the credentials, nil if the URI contains no credentials
+func splitCredentials(uri string) (string, *AuthEntry) {
+	u, err := url.Parse(uri)
+	if err != nil || u.User == nil || u.Host == "" {
+		return uri, nil
+	}
+	entry := &AuthEntry{Login: u.User.Username()}
+	entry.Password, _ = u.User.Password()
+	u.User = nil
+	entry.Machine = authMachine(u)
+	return u.String(), entry
+}
+
+// authMachine returns the machine of the auth entries that match only the
+// URI u and its subfolders
+func authMachine(u *url.URL) string {
+	res := u.Host + strings.TrimRight(u.Path, "/")
+	if u.Scheme != "https" && u.Scheme != "tor+https" {
+		res = u.Scheme + "://" + res
+	}
+	return res
+}
+
+// redactURI returns the URI with the password, if any, redacted
+func redactURI(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.User == nil {
+		return uri
+	}
+	return u.Redacted()
+}
+
+// uriPasswordRegexp matches the passwords of the URIs in a text
+var uriPasswordRegexp = regexp.MustCompile(`(://[^/\s:@]*:)[^/\s@]+@`)
+
+// redactText returns the text with the passwords of the URIs redacted
+func redactText(text string) string {
+	return uriPasswordRegexp.ReplaceAllString(text, "${1}xxxxx@")
+}
+
+// authStatement is an entry of an auth file with its position
+type authStatement struct {
+	entry      *AuthEntry
+	start, end int
+	// password is the position of the password, if any
+	passwordStart, passwordEnd int
+}
+
+// parseAuthConf parses the content of an auth file, in the netrc format
+// used by apt
+func parseAuthConf(path string, data string) ([]*authStatement, error) {
+	res := []*authStatement{}
+	var current *authStatement
+	var keyword string
+	line := 1
+	for i := 0; i < len(data); {
+		ch := data[i]
+		switch {
+		case ch == '\n':
+			line++
+			i++
+			continue
+		case ch == ' ' || ch == '\t' || ch == '\r':
+			i++
+			continue
+		case ch == '#':
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+			continue
+		}
+		start := i
+		for i < len(data) && !strings.ContainsRune(" \t\r\n", rune(data[i])) {
+			i++
+		}
+		token := data[start:i]
+		if keyword == "" {
+			switch token {
+			case "machine":
+				current = &authStatement{entry: &AuthEntry{File: path, Line: line}, start: start}
+				res = append(res, current)
+			case "login", "password":
+				if current == nil {
+					return nil, fmt.Errorf("line %d: '%s' without machine", line, token)
+				}
+			default:
+				return nil, fmt.Errorf("line %d: unexpected token", line)
+			}
+			keyword = token
+			continue
+		}
+		switch keyword {
+		case "machine":
+			current.entry.Machine = token
+		case "login":
+			current.entry.Login = token
+		case "password":
+			current.entry.Password = token
+			current.passwordStart, current.passwordEnd = start, i
+		}
+		current.end = i
+		keyword = ""
+	}
+	if keyword != "" {
+		return nil, fmt.Errorf("line %d: missing value of '%s'", line, keyword)
+	}
+	return res, nil
+}
+
+// redactAuthConf returns the content of an auth file with the passwords
+// redacted, or a placeholder if the file can't be parsed
+func redactAuthConf(data string) string {
+	statements, err := parseAuthConf("", data)
+	if err != nil {
+		return "# the content is not shown\n"
+	}
+	for i := len(statements) - 1; i >= 0; i-- {
+		if s := statements[i]; s.passwordEnd != 0 {
+			data = data[:s.passwordStart] + "xxxxx" + data[s.passwordEnd:]
+		}
+	}
+	return data
+}
+
+// authFile is an auth file parsed to be changed
+type authFile struct {
+	path       string
+	data       string
+	statements []*authStatement
+}
+
+// readAuthFiles reads the auth files in the specified APT config folder,
+// in the same order used by apt: the files in the "auth.conf.d" folder,
+// sorted by name, and then the "auth.conf" file.
+func readAuthFiles(fsys fs.FS, configFolderPath string) ([]*authFile, error) {
+	paths := []string{}
+	folder := filepath.Join(configFolderPath, "auth.conf.d")
+	if _, err := fs.Stat(fsys, folder); err == nil {
+		files, err := aptConfFiles(folder, &config{fs: fsys})
+		if err != nil {
+			return nil, fmt.Errorf("reading %s folder: %s", folder, err)
+		}
+		paths = append(paths, files...)
+	}
+	paths = append(paths, filepath.Join(configFolderPath, "auth.conf"))
+
+	res := []*authFile{}
+	for _, path := range paths {
+		data, err := fs.ReadFile(fsys, path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %s", path, err)
+		}
+		statements, err := parseAuthConf(path, string(data))
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %s", path, err)
+		}
+		res = append(res, &authFile{path: path, data: string(data), statements: statements})
+	}
+	return res, nil
+}
+
+// ParseAuthConf returns the credentials configured in the specified APT
+// config folder (usually /etc/apt), in the "auth.conf.d" folder and in
+// the "auth.conf" file, in the order used by apt: the first entry that
+// matches a URI is used.
+func ParseAuthConf(configFolderPath string, opts ...Option) ([]*AuthEntry, error) {
+	c := newConfig(opts)
+	files, err := readAuthFiles(c.fs, configFolderPath)
+	if err != nil {
+		return nil, err
+	}
+	res := []*AuthEntry{}
+	for _, f := range files {
+		for _, s := range f.statements {
+			res = append(res, s.entry)
+		}
+	}
+	return res, nil
+}
+
+// changeAuthEntries returns the changes of the auth files that set the
+// credentials of the entries and remove the ones of the machines in
+// unset. The existing entries of a machine are replaced, the new ones
+// are added to "auth.conf.d/managed.conf". It returns also the number of
+// entries removed.
+func changeAuthEntries(fsys fs.FS, configFolderPath string, set []*AuthEntry, unset []string) ([]*fileChange, int, error) {
+	files, err := readAuthFiles(fsys, configFolderPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	newEntry := func(machine string) *AuthEntry {
+		var res *AuthEntry
+		for _, e := range set {
+			if strings.EqualFold(e.Machine, machine) {
+				res = e
+			}
+		}
+		return res
+	}
+	isUnset := func(machine string) bool {
+		for _, m := range unset {
+			if strings.EqualFold(m, machine) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Only the first entry of a machine is used by apt, so the other
+	// entries of the changed machines are removed
+	type edit struct {
+		s    *authStatement
+		text string
+	}
+	type newFile struct {
+		f       *authFile
+		content string
+		left    int
+	}
+	newFiles := []*newFile{}
+	written := map[*AuthEntry]bool{}
+	removed := 0
+	for _, f := range files {
+		edits := []edit{}
+		left := len(f.statements)
+		for _, s := range f.statements {
+			e := newEntry(s.entry.Machine)
+			switch {
+			case e != nil && !written[e]:
+				written[e] = true
+				edits = append(edits, edit{s, e.format()})
+			case e != nil || isUnset(s.entry.Machine):
+				if e == nil {
+					removed++
+				}
+				edits = append(edits, edit{s, ""})
+				left--
+			}
+		}
+		content := f.data
+		for i := len(edits) - 1; i >= 0; i-- {
+			content = replaceSpan(content, edits[i].s.start, edits[i].s.end, edits[i].text)
+		}
+		newFiles = append(newFiles, &newFile{f: f, content: content, left: left})
+	}
+
+	// Add the new entries to the managed file
+	managedPath := filepath.Join(configFolderPath, "auth.conf.d", "managed.conf")
+	var managed *newFile
+	for _, nf := range newFiles {
+		if nf.f.path == managedPath {
+			managed = nf
+		}
+	}
+	for _, e := range set {
+		if written[e] || newEntry(e.Machine) != e {
+			continue
+		}
+		if managed == nil {
+			managed = &newFile{f: &authFile{path: managedPath}}
+			newFiles = append(newFiles, managed)
+		}
+		if managed.content != "" && !strings.HasSuffix(managed.content, "\n") {
+			managed.content += "\n"
+		}
+		managed.content += e.format() + "\n"
+		managed.left++
+	}
+
+	res := []*fileChange{}
+	for _, nf := range newFiles {
+		if nf.f.statements != nil && nf.content == nf.f.data {
+			continue
+		}
+		change := &fileChange{path: nf.f.path, content: []byte(nf.content), secret: true}
+		// The files in "auth.conf.d" are deleted once their last entry
+		// is removed
+		change.deleted = filepath.Dir(nf.f.path) == filepath.Dir(managedPath) && nf.left == 0
+		res = append(res, change)
+	}
+	return res, removed, nil
+}
+
+// SetAuthEntry sets the credentials of a machine in the specified APT
+// config folder (usually /etc/apt): the entry of the machine, if already
+// present in an auth file, is replaced, otherwise the entry is added to
+// "auth.conf.d/managed.conf". The new auth files are readable only by
+// the owner.
+func SetAuthEntry(entry *AuthEntry, configFolderPath string, opts ...Option) error {
+	if err := entry.validate(); err != nil {
+		return fmt.Errorf("invalid credentials: %s", err)
+	}
+	return writeAuthEntries([]*AuthEntry{entry}, nil, configFolderPath, newConfig(opts))
+}
+
+// RemoveAuthEntry removes the credentials of the machine from the auth
+// files in the specified APT config folder (usually /etc/apt). A file of
+// the "auth.conf.d" folder left without entries is deleted.
+func RemoveAuthEntry(machine string, configFolderPath string, opts ...Option) error {
+	return writeAuthEntries(nil, []string{machine}, configFolderPath, newConfig(opts))
+}
+
+func writeAuthEntries(set []*AuthEntry, unset []string, configFolderPath string, c *config) error {
+	unlock, err := c.lock(configFolderPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	fsys, err := c.writableFS()
+	if err != nil {
+		return err
+	}
+	changes, removed, err := changeAuthEntries(fsys, configFolderPath, set, unset)
+	if err != nil {
+		return err
+	}
+	if len(unset) > 0 && removed == 0 {
+		return fmt.Errorf("credentials already removed")
+	}
+	if err := writeSecretFiles(fsys, changes, c.backups); err != nil {
+		return fmt.Errorf("writing of new credentials: %s", err)
+	}
+	return nil
+}
+
+// writeSecretFiles is like writeSourceFiles, but creates the missing
+// folders of the files with credentials
+func writeSecretFiles(fsys WritableFS, changes []*fileChange, backups int) error {
+	for _, change := range changes {
+		if change.secret && !change.deleted {
+			if err := fsys.MkdirAll(filepath.Dir(change.path), 0755); err != nil {
+				return fmt.Errorf("creating %s folder: %s", filepath.Dir(change.path), err)
+			}
+		}
+	}
+	return writeSourceFiles(fsys, changes, backups)
+}
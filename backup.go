@@ -0,0 +1,187 @@
+//
+//  This file is part of go-apt-client library
+//
+//  Copyright (C) 2017  Arduino AG (http://www.arduino.cc/)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+//
+
+package apt
+
+import (
+	"cmp"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Backup is a backup copy of a source file
+type Backup struct {
+	// File is the source file
+	File string
+	// Path is the backup copy
+	Path string
+	// Index is 0 for the most recent copy, 1 for the previous one and so on
+	Index int
+	// ModTime is the time the backup copy was made
+	ModTime time.Time
+}
+
+// backupNameRegexp matches the names of the backup copies of the source
+// files, the submatches are the source file name and the index
+var backupNameRegexp = regexp.MustCompile(`^(.+\.(?:list|sources))(?:\.([1-9][0-9]*))?\.save$`)
+
+// WithBackups sets how many backup copies of each changed source file
+// are kept: the previous version is saved as "<file>.save", the older
+// ones as "<file>.1.save", "<file>.2.save" and so on. With 0 no new
+// backup copy is made. The default is 1.
+func WithBackups(n int) Option {
+	return func(c *config) {
+		c.backups = max(n, 0)
+	}
+}
+
+// backupPath returns the path of the i-th most recent backup copy of the
+// file, starting from 0
+func backupPath(path string, i int) string {
+	if i == 0 {
+		return path + ".save"
+	}
+	return fmt.Sprintf("%s.%d.save", path, i)
+}
+
+// keepBackup moves the previous version of the file, saved in the
+// file oldPath, to the most recent backup copy and shifts the older
+// copies, deleting the ones exceeding the given number of backups.
+func keepBackup(fsys WritableFS, path, oldPath string, backups int) error {
+	if backups == 0 {
+		return fsys.Remove(oldPath)
+	}
+	if err := fsys.Remove(backupPath(path, backups-1)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := backups - 2; i >= 0; i-- {
+		if _, err := fs.Stat(fsys, backupPath(path, i)); os.IsNotExist(err) {
+			continue
+		}
+		if err := fsys.Rename(backupPath(path, i), backupPath(path, i+1)); err != nil {
+			return err
+		}
+	}
+	return fsys.Rename(oldPath, backupPath(path, 0))
+}
+
+// ListBackups returns the backup copies of the source files found in the
+// specified APT config folder (usually /etc/apt), sorted by source file
+// and from the most recent copy. The source files may not exist anymore.
+func ListBackups(configFolderPath string, opts ...Option) ([]*Backup, error) {
+	c := newConfig(opts)
+	res := []*Backup{}
+	for _, folder := range []string{configFolderPath, filepath.Join(configFolderPath, "sources.list.d")} {
+		list, err := fs.ReadDir(c.fs, folder)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s folder: %s", folder, err)
+		}
+		for _, l := range list {
+			m := backupNameRegexp.FindStringSubmatch(l.Name())
+			if m == nil || l.IsDir() || (folder == configFolderPath && m[1] != "sources.list") {
+				continue
+			}
+			info, err := l.Info()
+			if err != nil {
+				return nil, fmt.Errorf("reading %s: %s", l.Name(), err)
+			}
+			backup := &Backup{
+				File:    filepath.Join(folder, m[1]),
+				Path:    filepath.Join(folder, l.Name()),
+				ModTime: info.ModTime(),
+			}
+			if m[2] != "" {
+				backup.Index, _ = strconv.Atoi(m[2])
+			}
+			res = append(res, backup)
+		}
+	}
+	slices.SortStableFunc(res, func(a, b *Backup) int {
+		return cmp.Or(cmp.Compare(a.File, b.File), cmp.Compare(a.Index, b.Index))
+	})
+	return res, nil
+}
+
+// check verifies that the backup is a copy of a source file of the
+// specified APT config folder
+func (b *Backup) check(configFolderPath string) error {
+	dir := filepath.Dir(b.File)
+	if b.Index < 0 || b.Path != backupPath(b.File, b.Index) ||
+		!(b.File == filepath.Join(configFolderPath, "sources.list") || dir == filepath.Join(configFolderPath, "sources.list.d")) ||
+		!sourceFileNameRegexp.MatchString(filepath.Base(b.File)) {
+		return fmt.Errorf("invalid backup %s", b.Path)
+	}
+	return nil
+}
+
+// DiffBackup returns the changes that RestoreBackup would make to the
+// source file, an empty Diff if the backup copy is equal to the file.
+func DiffBackup(backup *Backup, configFolderPath string, opts ...Option) (*FileDiff, error) {
+	c := newConfig(opts)
+	if err := backup.check(configFolderPath); err != nil {
+		return nil, err
+	}
+	data, err := fs.ReadFile(c.fs, backup.Path)
+	if err != nil {
+		return nil, fmt.Errorf("reading backup: %s", err)
+	}
+	current, err := fs.ReadFile(c.fs, backup.File)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading config file %s: %s", backup.File, err)
+	}
+	res := &FileDiff{Path: backup.File, Created: os.IsNotExist(err)}
+	oldName := backup.File
+	if res.Created {
+		oldName = "/dev/null"
+	}
+	res.Diff = unifiedDiff(oldName, backup.Path, string(current), string(data))
+	return res, nil
+}
+
+// RestoreBackup replaces the source file with the backup copy. The
+// current version of the file becomes, in turn, the most recent backup
+// copy (see WithBackups), so the restore can be undone.
+func RestoreBackup(backup *Backup, configFolderPath string, opts ...Option) error {
+	c := newConfig(opts)
+	if err := backup.check(configFolderPath); err != nil {
+		return err
+	}
+	unlock, err := c.lock(configFolderPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	fsys, err := c.writableFS()
+	if err != nil {
+		return err
+	}
+	data, err := fs.ReadFile(fsys, backup.Path)
+	if err != nil {
+		return fmt.Errorf("reading backup: %s", err)
+	}
+	if err := writeSourceFiles(fsys, []*fileChange{{path: backup.File, content: data}}, c.backups); err != nil {
+		return fmt.Errorf("restoring backup: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+//
+//  This file is part of go-apt-client library
+//
+//  Copyright (C) 2017  Arduino AG (http://www.arduino.cc/)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+//
+
+package apt
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackupRetention(t *testing.T) {
+	fsys := NewMemFS(map[string]string{"etc/apt/sources.list": ""})
+	require.NoError(t, fsys.MkdirAll("etc/apt/sources.list.d", 0755))
+	add := func(dist string, opts ...Option) {
+		repo := &Repository{Enabled: true, URI: "http://example.com/debian", Distribution: dist, Components: "main"}
+		opts = append(opts, WithFS(fsys), WithTargetFile("test.list"))
+		require.NoError(t, AddRepository(repo, "etc/apt", opts...))
+	}
+	exists := func(name string) bool {
+		_, err := fs.Stat(fsys, "etc/apt/sources.list.d/"+name)
+		return !os.IsNotExist(err)
+	}
+
+	add("a", WithBackups(3))
+	require.False(t, exists("test.list.save"))
+	add("b", WithBackups(3))
+	add("c", WithBackups(3))
+	add("d", WithBackups(3))
+	add("e", WithBackups(3))
+	require.Equal(t, "deb http://example.com/debian a main\n"+
+		"deb http://example.com/debian b main\n"+
+		"deb http://example.com/debian c main\n"+
+		"deb http://example.com/debian d main\n", readFSFile(t, fsys, "etc/apt/sources.list.d/test.list.save"))
+	require.Equal(t, "deb http://example.com/debian a main\n"+
+		"deb http://example.com/debian b main\n"+
+		"deb http://example.com/debian c main\n", readFSFile(t, fsys, "etc/apt/sources.list.d/test.list.1.save"))
+	require.Equal(t, "deb http://example.com/debian a main\n"+
+		"deb http://example.com/debian b main\n", readFSFile(t, fsys, "etc/apt/sources.list.d/test.list.2.save"))
+	require.False(t, exists("test.list.3.save"))
+
+	// The default replaces only the most recent copy
+	add("f")
+	require.NotContains(t, readFSFile(t, fsys, "etc/apt/sources.list.d/test.list.1.save"), " d main")
+	require.Contains(t, readFSFile(t, fsys, "etc/apt/sources.list.d/test.list.save"), " e main")
+
+	// No new copy is made, the existing ones are left untouched
+	add("g", WithBackups(0))
+	require.Contains(t, readFSFile(t, fsys, "etc/apt/sources.list.d/test.list.save"), " e main")
+	require.False(t, exists("test.list.orig"))
+	require.Contains(t, readFSFile(t, fsys, "etc/apt/sources.list.d/test.list"), " g main")
+}
+
+func TestBackupModTime(t *testing.T) {
+	folder := copyTestFolder(t, "testdata/legacy-keys/apt")
+	old := time.Now().Add(-48 * time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(folder, "sources.list"), old, old))
+	repos, err := ParseAPTConfigFolder(folder)
+	require.NoError(t, err)
+	require.NoError(t, RemoveRepository(repos[0], folder))
+
+	backups, err := ListBackups(folder)
+	require.NoError(t, err)
+	require.Len(t, backups, 1)
+	require.WithinDuration(t, time.Now(), backups[0].ModTime, time.Minute)
+	info, err := os.Stat(filepath.Join(folder, "sources.list"))
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now(), info.ModTime(), time.Minute)
+}
+
+func TestListAndRestoreBackups(t *testing.T) {
+	fsys := newTransactionTestFS()
+	repos, err := ParseAPTConfigFolder("etc/apt", WithFS(fsys))
+	require.NoError(t, err)
+
+	backups, err := ListBackups("etc/apt", WithFS(fsys))
+	require.NoError(t, err)
+	require.Empty(t, backups)
+
+	require.NoError(t, RemoveRepository(repos[0], "etc/apt", WithFS(fsys), WithBackups(2)))
+	require.NoError(t, RemoveRepository(repos[3], "etc/apt", WithFS(fsys)))
+	repos, err = ParseAPTConfigFolder("etc/apt", WithFS(fsys))
+	require.NoError(t, err)
+	require.NoError(t, RemoveRepository(repos[0], "etc/apt", WithFS(fsys), WithBackups(2)))
+	require.NoError(t, fsys.WriteFile("etc/apt/sources.list.d/unrelated.save", nil, 0644))
+	require.NoError(t, fsys.WriteFile("etc/apt/other.list.save", nil, 0644))
+
+	backups, err = ListBackups("etc/apt", WithFS(fsys))
+	require.NoError(t, err)
+	require.Len(t, backups, 3)
+	require.Equal(t, "etc/apt/sources.list", backups[0].File)
+	require.Equal(t, "etc/apt/sources.list.save", backups[0].Path)
+	require.Equal(t, 0, backups[0].Index)
+	require.False(t, backups[0].ModTime.IsZero())
+	require.Equal(t, "etc/apt/sources.list.1.save", backups[1].Path)
+	require.Equal(t, 1, backups[1].Index)
+	require.Equal(t, "etc/apt/sources.list.d/other.list", backups[2].File)
+	require.Equal(t, "etc/apt/sources.list.d/other.list.save", backups[2].Path)
+
+	diff, err := DiffBackup(backups[1], "etc/apt", WithFS(fsys))
+	require.NoError(t, err)
+	require.Equal(t, &FileDiff{
+		Path: "etc/apt/sources.list",
+		Diff: "--- etc/apt/sources.list\n" +
+			"+++ etc/apt/sources.list.1.save\n" +
+			"@@ -1 +1,3 @@\n" +
+			"+deb http://example.com/debian stable main\n" +
+			"+deb http://example.com/debian testing main\n" +
+			" deb http://example.com/debian unstable main\n",
+	}, diff)
+	diff, err = DiffBackup(backups[2], "etc/apt", WithFS(fsys))
+	require.NoError(t, err)
+	require.True(t, diff.Created)
+	require.Equal(t, "--- /dev/null\n"+
+		"+++ etc/apt/sources.list.d/other.list.save\n"+
+		"@@ -0,0 +1 @@\n"+
+		"+deb http://other.example.com/debian stable main\n", diff.Diff)
+
+	// The restore can be undone
+	require.NoError(t, RestoreBackup(backups[1], "etc/apt", WithFS(fsys)))
+	require.Equal(t, txSourcesList, readFSFile(t, fsys, "etc/apt/sources.list"))
+	require.Equal(t, "deb http://example.com/debian unstable main\n", readFSFile(t, fsys, "etc/apt/sources.list.save"))
+	require.NoError(t, RestoreBackup(backups[0], "etc/apt", WithFS(fsys)))
+	require.Equal(t, "deb http://example.com/debian unstable main\n", readFSFile(t, fsys, "etc/apt/sources.list"))
+
+	// Deleted files are restored
+	require.NoError(t, RestoreBackup(backups[2], "etc/apt", WithFS(fsys)))
+	require.Equal(t, txOtherList, readFSFile(t, fsys, "etc/apt/sources.list.d/other.list"))
+
+	invalid := *backups[0]
+	invalid.Path = "etc/apt/sources.list.d/other.list.save"
+	require.EqualError(t, RestoreBackup(&invalid, "etc/apt", WithFS(fsys)), "invalid backup etc/apt/sources.list.d/other.list.save")
+	_, err = DiffBackup(backups[0], "etc/other", WithFS(fsys))
+	require.EqualError(t, err, "invalid backup etc/apt/sources.list.save")
+}
@@ -0,0 +1,321 @@
+//
+//  This file is part of go-apt-client library
+//
+//  Copyright (C) 2017  Arduino AG (http://www.arduino.cc/)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+//
+
+package apt
+
+import (
+	"slices"
+	"strings"
+)
+
+// deb822File is a deb822-style file (like the "*.sources" files in
+// "sources.list.d"). The file is kept as a sequence of paragraphs that
+// retain the original text, so that it can be written back unchanged
+// except for the paragraphs that have been explicitly modified.
+type deb822File struct {
+	paragraphs []*deb822Paragraph
+}
+
+// deb822Paragraph is either a run of non-blank lines (a stanza, possibly
+// made only of comments) or a run of blank lines separating two stanzas.
+type deb822Paragraph struct {
+	separator bool
+	items     []*deb822Item
+}
+
+// deb822Item is a field (with all its continuation lines) or a comment
+// line inside a paragraph. Every line retains its original terminator.
+type deb822Item struct {
+	name  string // empty for comments
+	lines []string
+}
+
+func parseDeb822(data []byte) *deb822File {
+	res := &deb822File{}
+	var current *deb822Paragraph
+	for _, line := range splitLines(string(data)) {
+		text := trimEOL(line)
+		blank := strings.TrimSpace(text) == ""
+		if current == nil || current.separator != blank {
+			current = &deb822Paragraph{separator: blank}
+			res.paragraphs = append(res.paragraphs, current)
+		}
+		if blank {
+			current.items = append(current.items, &deb822Item{lines: []string{line}})
+			continue
+		}
+		if strings.HasPrefix(text, "#") {
+			current.items = append(current.items, &deb822Item{lines: []string{line}})
+			continue
+		}
+		if text[0] == ' ' || text[0] == '\t' {
+			if last := current.lastField(); last != nil {
+				last.lines = append(last.lines, line)
+				continue
+			}
+		}
+		name, _, _ := strings.Cut(text, ":")
+		current.items = append(current.items, &deb822Item{name: strings.TrimSpace(name), lines: []string{line}})
+	}
+	return res
+}
+
+// Bytes returns the content of the file
+func (f *deb822File) Bytes() []byte {
+	var res strings.Builder
+	for _, p := range f.paragraphs {
+		for _, item := range p.items {
+			for _, line := range item.lines {
+				res.WriteString(line)
+			}
+		}
+	}
+	return []byte(res.String())
+}
+
+// stanzas returns the paragraphs that contains at least one field
+func (f *deb822File) stanzas() []*deb822Paragraph {
+	res := []*deb822Paragraph{}
+	for _, p := range f.paragraphs {
+		if !p.separator && p.lastField() != nil {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// append adds a paragraph at the end of the file, separated from
+// the previous one by a blank line.
+func (f *deb822File) append(p *deb822Paragraph) {
+	f.insert(len(f.paragraphs), p)
+}
+
+// insertAfter adds the paragraphs right after the paragraph ref.
+func (f *deb822File) insertAfter(ref *deb822Paragraph, paragraphs ...*deb822Paragraph) {
+	idx := slices.Index(f.paragraphs, ref)
+	for i, p := range paragraphs {
+		f.insert(idx+1+i*2, p)
+	}
+}
+
+// insert adds the paragraph p at position idx followed or preceded by
+// a blank line separator, so that it is separated from both the previous
+// and the next stanza.
+func (f *deb822File) insert(idx int, p *deb822Paragraph) {
+	sep := &deb822Paragraph{separator: true, items: []*deb822Item{{lines: []string{"\n"}}}}
+	if idx > 0 {
+		f.paragraphs[idx-1].terminate()
+	}
+	if idx < len(f.paragraphs) {
+		if idx > 0 && !f.paragraphs[idx-1].separator {
+			// Right after a stanza, the separator goes first
+			f.paragraphs = slices.Insert(f.paragraphs, idx, sep, p)
+		} else {
+			f.paragraphs = slices.Insert(f.paragraphs, idx, p, sep)
+		}
+		return
+	}
+	if idx > 0 && !f.paragraphs[idx-1].separator {
+		f.paragraphs = append(f.paragraphs, sep)
+	}
+	f.paragraphs = append(f.paragraphs, p)
+}
+
+// remove deletes the paragraph p and the blank line separator that
+// follows (or precedes) it.
+func (f *deb822File) remove(p *deb822Paragraph) {
+	idx := slices.Index(f.paragraphs, p)
+	if idx == -1 {
+		return
+	}
+	switch {
+	case idx+1 < len(f.paragraphs) && f.paragraphs[idx+1].separator:
+		f.paragraphs = slices.Delete(f.paragraphs, idx, idx+2)
+	case idx > 0 && f.paragraphs[idx-1].separator:
+		f.paragraphs = slices.Delete(f.paragraphs, idx-1, idx+1)
+	default:
+		f.paragraphs = slices.Delete(f.paragraphs, idx, idx+1)
+	}
+}
+
+// terminate ensures that the last line of the paragraph ends with a
+// line terminator.
+func (p *deb822Paragraph) terminate() {
+	if len(p.items) == 0 {
+		return
+	}
+	last := p.items[len(p.items)-1]
+	if l := last.lines[len(last.lines)-1]; !strings.HasSuffix(l, "\n") {
+		last.lines[len(last.lines)-1] = l + "\n"
+	}
+}
+
+func (p *deb822Paragraph) lastField() *deb822Item {
+	for i := len(p.items) - 1; i >= 0; i-- {
+		if p.items[i].name != "" {
+			return p.items[i]
+		}
+	}
+	return nil
+}
+
+func (p *deb822Paragraph) field(name string) *deb822Item {
+	for _, item := range p.items {
+		if item.name != "" && strings.EqualFold(item.name, name) {
+			return item
+		}
+	}
+	return nil
+}
+
+// has returns true if the field is present in the paragraph
+func (p *deb822Paragraph) has(name string) bool {
+	return p.field(name) != nil
+}
+
+// get returns the value of the field, multi-line values are joined
+// with a newline.
+func (p *deb822Paragraph) get(name string) string {
+	item := p.field(name)
+	if item == nil {
+		return ""
+	}
+	return item.value()
+}
+
+// getList returns the value of the field as a whitespace-separated list
+func (p *deb822Paragraph) getList(name string) []string {
+	return strings.Fields(p.get(name))
+}
+
+// set changes the value of the field, or adds it at the end of the
+// paragraph if it is missing. The original spelling of the field name
+// and of the separator is retained.
+func (p *deb822Paragraph) set(name, value string) {
+	item := p.field(name)
+	if item == nil {
+		p.terminate()
+		item = &deb822Item{name: name}
+		p.items = append(p.items, item)
+		item.lines = formatDeb822Field(name+": ", value, "\n")
+		return
+	}
+	if item.value() == value {
+		return
+	}
+	first := item.lines[0]
+	text := trimEOL(first)
+	eol := first[len(text):]
+	if eol == "" {
+		eol = "\n"
+	}
+	idx := strings.Index(text, ":") + 1
+	prefix := text[:idx]
+	for idx < len(text) && (text[idx] == ' ' || text[idx] == '\t') {
+		idx++
+	}
+	if idx == len(text) {
+		prefix += " "
+	} else {
+		prefix = text[:idx]
+	}
+	lines := formatDeb822Field(prefix, value, eol)
+	if last := item.lines[len(item.lines)-1]; !strings.HasSuffix(last, "\n") {
+		lines[len(lines)-1] = trimEOL(lines[len(lines)-1])
+	}
+	item.lines = lines
+}
+
+// del removes the field from the paragraph
+func (p *deb822Paragraph) del(name string) {
+	p.items = slices.DeleteFunc(p.items, func(item *deb822Item) bool {
+		return item.name != "" && strings.EqualFold(item.name, name)
+	})
+}
+
+// clone returns a copy of the paragraph without the comments
+func (p *deb822Paragraph) clone() *deb822Paragraph {
+	res := &deb822Paragraph{}
+	for _, item := range p.items {
+		if item.name == "" {
+			continue
+		}
+		res.items = append(res.items, &deb822Item{name: item.name, lines: slices.Clone(item.lines)})
+	}
+	res.terminate()
+	return res
+}
+
+func (item *deb822Item) value() string {
+	_, first, _ := strings.Cut(trimEOL(item.lines[0]), ":")
+	res := []string{strings.TrimSpace(first)}
+	for _, line := range item.lines[1:] {
+		line = strings.TrimSpace(line)
+		if line == "." {
+			line = ""
+		}
+		res = append(res, line)
+	}
+	if res[0] == "" {
+		res = res[1:]
+	}
+	return strings.Join(res, "\n")
+}
+
+func formatDeb822Field(prefix, value, eol string) []string {
+	lines := strings.Split(value, "\n")
+	res := []string{prefix + lines[0] + eol}
+	for _, line := range lines[1:] {
+		if line == "" {
+			line = "."
+		}
+		res = append(res, " "+line+eol)
+	}
+	return res
+}
+
+// splitLines splits the text in lines, each line retains its terminator
+func splitLines(text string) []string {
+	res := []string{}
+	for text != "" {
+		idx := strings.IndexByte(text, '\n')
+		if idx == -1 {
+			res = append(res, text)
+			break
+		}
+		res = append(res, text[:idx+1])
+		text = text[idx+1:]
+	}
+	return res
+}
+
+func trimEOL(line string) string {
+	line = strings.TrimSuffix(line, "\n")
+	return strings.TrimSuffix(line, "\r")
+}
+
+// parseDeb822Bool parses a boolean value the same way apt does
+func parseDeb822Bool(value string, def bool) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "no", "false", "without", "off", "disable":
+		return false
+	case "yes", "true", "with", "on", "enable":
+		return true
+	}
+	return def
+}
@@ -0,0 +1,93 @@
+//
+//  This file is part of go-apt-client library
+//
+//  Copyright (C) 2017  Arduino AG (http://www.arduino.cc/)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+//
+
+package apt
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Severity is the severity of a Diagnostic
+type Severity string
+
+const (
+	// SeverityInfo is used by Lint for findings that don't affect apt,
+	// like leftover entries
+	SeverityInfo Severity = "info"
+	// SeverityWarning is used for malformed entries that apt ignores,
+	// like disabled entries
+	SeverityWarning Severity = "warning"
+	// SeverityError is used for malformed entries that apt rejects
+	SeverityError Severity = "error"
+)
+
+// Diagnostic describes a malformed entry found in an APT source file
+type Diagnostic struct {
+	File     string
+	Line     int
+	Severity Severity
+	Reason   string
+	// Text is the original text of the entry, with the passwords of the
+	// URIs redacted
+	Text string
+}
+
+// String returns the diagnostic in the "file:line: severity: reason" form
+func (d *Diagnostic) String() string {
+	return fmt.Sprintf("%s:%d: %s: %s", d.File, d.Line, d.Severity, d.Reason)
+}
+
+// ParseMode selects how malformed entries are handled while parsing
+type ParseMode int
+
+const (
+	// ParseLenient skips the malformed entries
+	ParseLenient ParseMode = iota
+	// ParseStrict fails if an entry that apt would reject is found
+	ParseStrict
+)
+
+// ParseError is the error returned in ParseStrict mode, it contains
+// the diagnostics with SeverityError.
+type ParseError struct {
+	Diagnostics []*Diagnostic
+}
+
+func (e *ParseError) Error() string {
+	res := []string{}
+	for _, d := range e.Diagnostics {
+		res = append(res, d.String())
+	}
+	return "malformed source entries: " + strings.Join(res, "; ")
+}
+
+// newParseError returns a ParseError with the diagnostics with
+// SeverityError, or nil if there are none.
+func newParseError(diagnostics []*Diagnostic) error {
+	errs := []*Diagnostic{}
+	for _, d := range diagnostics {
+		if d.Severity == SeverityError {
+			errs = append(errs, d)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return &ParseError{Diagnostics: errs}
+}
@@ -0,0 +1,130 @@
+//
+//  This file is part of go-apt-client library
+//
+//  Copyright (C) 2017  Arduino AG (http://www.arduino.cc/)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+//
+
+package apt
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLenientSourceListParsing(t *testing.T) {
+	data := "deb  http://example.com/a stable main\n" +
+		"deb\thttp://example.com/b\tstable\tmain contrib\n" +
+		"#deb http://example.com/c stable main\n" +
+		"#  deb-src [ arch=amd64 ] http://example.com/d stable main#comment\n" +
+		"## deb http://example.com/e stable main\n" +
+		"# debian mirror\n"
+	repos := parseSourceListFile([]byte(data)).repositories()
+	require.Len(t, repos, 4)
+
+	require.True(t, repos[0].Enabled)
+	require.Equal(t, "http://example.com/a", repos[0].URI)
+	require.Equal(t, "main", repos[0].Components)
+
+	require.Equal(t, "http://example.com/b", repos[1].URI)
+	require.Equal(t, "main contrib", repos[1].Components)
+
+	require.False(t, repos[2].Enabled)
+	require.Equal(t, "http://example.com/c", repos[2].URI)
+
+	require.False(t, repos[3].Enabled)
+	require.True(t, repos[3].SourceRepo)
+	require.Equal(t, []string{"amd64"}, repos[3].Options.Architectures.Set)
+	require.Equal(t, "main", repos[3].Components)
+	require.Equal(t, "comment", repos[3].Comment)
+}
+
+func TestSourceListDiagnostics(t *testing.T) {
+	data := "deb http://example.com/a stable main\n" +
+		"deb http://example.com/b stable\n" +
+		"deb [arch=amd64 http://example.com/c stable main\n" +
+		"rpm http://example.com/d stable main\n" +
+		"# deb http://example.com/e\n" +
+		"deb [trusted=maybe] http://example.com/f stable main\n"
+	diags := parseSourceListFile([]byte(data)).diagnostics()
+	require.Len(t, diags, 5)
+	require.Equal(t, &Diagnostic{Line: 2, Severity: SeverityError, Reason: "missing components", Text: "deb http://example.com/b stable"}, diags[0])
+	require.Equal(t, "missing ']' at the end of the options", diags[1].Reason)
+	require.Equal(t, "unknown source type 'rpm'", diags[2].Reason)
+	require.Equal(t, 5, diags[3].Line)
+	require.Equal(t, SeverityWarning, diags[3].Severity)
+	require.Equal(t, "missing distribution", diags[3].Reason)
+	require.Equal(t, 6, diags[4].Line)
+	require.Contains(t, diags[4].Reason, "invalid options")
+}
+
+func TestDeb822Diagnostics(t *testing.T) {
+	data := "Types: deb\n" +
+		"URIs: http://example.com/a\n" +
+		"Suites: stable\n" +
+		"Components: main\n" +
+		"\n" +
+		"Types: deb rpm\n" +
+		"URIs: http://example.com/b\n" +
+		"Suites stable\n" +
+		"Components: main\n" +
+		"\n" +
+		"Enabled: no\n" +
+		"Types: deb\n" +
+		"URIs: http://example.com/c\n" +
+		"Suites: stable\n"
+	diags := newSourcesFile([]byte(data)).diagnostics()
+	require.Len(t, diags, 4)
+	require.Equal(t, &Diagnostic{Line: 8, Severity: SeverityError, Reason: "missing ':' after the field name", Text: "Suites stable"}, diags[0])
+	require.Equal(t, 6, diags[1].Line)
+	require.Equal(t, "missing Suites field", diags[1].Reason)
+	require.Equal(t, "unknown source type 'rpm'", diags[2].Reason)
+	require.Equal(t, 11, diags[3].Line)
+	require.Equal(t, SeverityWarning, diags[3].Severity)
+	require.Equal(t, "missing Components field", diags[3].Reason)
+}
+
+func TestParseAPTConfigFolderWithDiagnostics(t *testing.T) {
+	folder := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(folder, "sources.list.d"), 0755))
+	data := "deb http://example.com/a stable main\n" +
+		"# deb http://example.com/b\n"
+	require.NoError(t, os.WriteFile(filepath.Join(folder, "sources.list"), []byte(data), 0644))
+
+	repos, diags, err := ParseAPTConfigFolderWithDiagnostics(folder, ParseStrict)
+	require.NoError(t, err)
+	require.Len(t, repos, 1)
+	require.Len(t, diags, 1)
+	require.Equal(t, filepath.Join(folder, "sources.list"), diags[0].File)
+	require.Equal(t, SeverityWarning, diags[0].Severity)
+
+	data += "deb http://example.com/c\n"
+	require.NoError(t, os.WriteFile(filepath.Join(folder, "sources.list"), []byte(data), 0644))
+
+	repos, diags, err = ParseAPTConfigFolderWithDiagnostics(folder, ParseLenient)
+	require.NoError(t, err)
+	require.Len(t, repos, 1)
+	require.Len(t, diags, 2)
+
+	_, _, err = ParseAPTConfigFolderWithDiagnostics(folder, ParseStrict)
+	require.Error(t, err)
+	parseErr, ok := err.(*ParseError)
+	require.True(t, ok)
+	require.Len(t, parseErr.Diagnostics, 1)
+	require.Equal(t, 3, parseErr.Diagnostics[0].Line)
+	require.Equal(t, "missing distribution", parseErr.Diagnostics[0].Reason)
+}
@@ -0,0 +1,237 @@
+//
+//  This file is part of go-apt-client library
+//
+//  Copyright (C) 2017  Arduino AG (http://www.arduino.cc/)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+//
+
+package apt
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around the changes
+const diffContext = 3
+
+// FileDiff is the change of a file computed by a preview
+type FileDiff struct {
+	Path string
+	// Created is true if the file doesn't exist yet
+	Created bool
+	// Deleted is true if the file is deleted, because its last repository
+	// is removed
+	Deleted bool
+	// Diff is the change in the unified diff format
+	Diff string
+}
+
+// PreviewAddRepository returns the changes that AddRepository would make,
+// without writing anything.
+func PreviewAddRepository(repo *Repository, configFolderPath string, opts ...Option) ([]*FileDiff, error) {
+	tx := NewTransaction(configFolderPath, opts...)
+	tx.AddRepository(repo)
+	return tx.Preview()
+}
+
+// PreviewEditRepository returns the changes that EditRepository would
+// make, without writing anything.
+func PreviewEditRepository(old *Repository, newRepo *Repository, configFolderPath string, opts ...Option) ([]*FileDiff, error) {
+	tx := NewTransaction(configFolderPath, opts...)
+	tx.EditRepository(old, newRepo)
+	return tx.Preview()
+}
+
+// PreviewRemoveRepository returns the changes that RemoveRepository would
+// make, without writing anything. The keyrings that would be deleted
+// because no longer used are not reported.
+func PreviewRemoveRepository(repo *Repository, configFolderPath string, opts ...Option) ([]*FileDiff, error) {
+	tx := NewTransaction(configFolderPath, opts...)
+	tx.RemoveRepository(repo)
+	return tx.Preview()
+}
+
+// Preview checks the collected changes and returns, for every source file
+// that would be changed by Commit, the unified diff of the change. Nothing
+// is written, so a read-only file system can be used.
+func (t *Transaction) Preview() ([]*FileDiff, error) {
+	changes, err := t.apply(t.c.fs)
+	if err != nil {
+		return nil, err
+	}
+	res := []*FileDiff{}
+	for _, change := range changes {
+		path := change.path
+		old, err := fs.ReadFile(t.c.fs, path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("reading config file %s: %s", path, err)
+		}
+		diff := &FileDiff{Path: path, Created: os.IsNotExist(err), Deleted: change.deleted}
+		oldName, newName, content := path, path, string(change.content)
+		if diff.Created {
+			oldName = "/dev/null"
+		}
+		if diff.Deleted {
+			newName, content = "/dev/null", ""
+		} else if string(old) == content {
+			continue
+		}
+		// The passwords are never shown
+		oldText := redactText(string(old))
+		if change.secret {
+			oldText, content = redactAuthConf(string(old)), redactAuthConf(content)
+		} else {
+			content = redactText(content)
+		}
+		diff.Diff = unifiedDiff(oldName, newName, oldText, content)
+		res = append(res, diff)
+	}
+	return res, nil
+}
+
+// diffEdit is a line of a diff, kind is one of ' ', '-' or '+'
+type diffEdit struct {
+	kind byte
+	line string
+}
+
+// diffLines computes the shortest edit script that transforms the lines a
+// in the lines b, using the Myers algorithm.
+func diffLines(a, b []string) []diffEdit {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*(n+m)+3)
+	trace := [][]int{}
+search:
+	for d := 0; d <= n+m; d++ {
+		trace = append(trace, slices.Clone(v))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	// Follow back the path found
+	res := []diffEdit{}
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			res = append(res, diffEdit{' ', a[x-1]})
+			x--
+			y--
+		}
+		if x == prevX {
+			res = append(res, diffEdit{'+', b[y-1]})
+			y--
+		} else {
+			res = append(res, diffEdit{'-', a[x-1]})
+			x--
+		}
+	}
+	for x > 0 && y > 0 {
+		res = append(res, diffEdit{' ', a[x-1]})
+		x--
+		y--
+	}
+	slices.Reverse(res)
+	return res
+}
+
+// unifiedDiff returns the difference between the two texts in the
+// unified diff format, an empty string if there are no differences.
+func unifiedDiff(oldName, newName, oldText, newText string) string {
+	edits := diffLines(splitLines(oldText), splitLines(newText))
+
+	// Group the changes in hunks, with their context
+	type hunk struct{ start, end int }
+	hunks := []hunk{}
+	for i, e := range edits {
+		if e.kind == ' ' {
+			continue
+		}
+		if n := len(hunks); n > 0 && i-diffContext <= hunks[n-1].end {
+			hunks[n-1].end = min(i+1+diffContext, len(edits))
+			continue
+		}
+		hunks = append(hunks, hunk{max(i-diffContext, 0), min(i+1+diffContext, len(edits))})
+	}
+	if len(hunks) == 0 {
+		return ""
+	}
+
+	// Line numbers before each edit
+	oldLine := make([]int, len(edits)+1)
+	newLine := make([]int, len(edits)+1)
+	for i, e := range edits {
+		oldLine[i+1], newLine[i+1] = oldLine[i], newLine[i]
+		if e.kind != '+' {
+			oldLine[i+1]++
+		}
+		if e.kind != '-' {
+			newLine[i+1]++
+		}
+	}
+	lineRange := func(start, count int) string {
+		if count == 0 {
+			return fmt.Sprintf("%d,0", start)
+		}
+		if count == 1 {
+			return fmt.Sprintf("%d", start+1)
+		}
+		return fmt.Sprintf("%d,%d", start+1, count)
+	}
+
+	var res strings.Builder
+	res.WriteString("--- " + oldName + "\n")
+	res.WriteString("+++ " + newName + "\n")
+	for _, h := range hunks {
+		oldStart, newStart := oldLine[h.start], newLine[h.start]
+		fmt.Fprintf(&res, "@@ -%s +%s @@\n",
+			lineRange(oldStart, oldLine[h.end]-oldStart),
+			lineRange(newStart, newLine[h.end]-newStart))
+		for _, e := range edits[h.start:h.end] {
+			res.WriteByte(e.kind)
+			res.WriteString(e.line)
+			if !strings.HasSuffix(e.line, "\n") {
+				res.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+	}
+	return res.String()
+}
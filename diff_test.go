@@ -0,0 +1,115 @@
+//
+//  This file is part of go-apt-client library
+//
+//  Copyright (C) 2017  Arduino AG (http://www.arduino.cc/)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+//
+
+package apt
+
+import (
+	"io/fs"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	require.Equal(t, "", unifiedDiff("a", "b", "1\n2\n", "1\n2\n"))
+
+	old := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n"
+	changed := "1\n2\n3\nfour\n5\n6\n7\n8\n9\n10\n11\n12\n14\n15\n16"
+	require.Equal(t, "--- a\n"+
+		"+++ b\n"+
+		"@@ -1,7 +1,7 @@\n"+
+		" 1\n 2\n 3\n-4\n+four\n 5\n 6\n 7\n"+
+		"@@ -10,6 +10,6 @@\n"+
+		" 10\n 11\n 12\n-13\n 14\n 15\n+16\n\\ No newline at end of file\n",
+		unifiedDiff("a", "b", old, changed))
+
+	require.Equal(t, "--- /dev/null\n+++ b\n@@ -0,0 +1,2 @@\n+1\n+2\n", unifiedDiff("/dev/null", "b", "", "1\n2\n"))
+	require.Equal(t, "--- a\n+++ b\n@@ -1,2 +0,0 @@\n-1\n-2\n", unifiedDiff("a", "b", "1\n2\n", ""))
+	require.Equal(t, "--- a\n+++ b\n@@ -1,3 +1,2 @@\n 1\n-2\n 3\n", unifiedDiff("a", "b", "1\n2\n3\n", "1\n3\n"))
+}
+
+func TestPreviewRepositoryChanges(t *testing.T) {
+	fsys := newTransactionTestFS()
+	repos, err := ParseAPTConfigFolder("etc/apt", WithFS(fsys))
+	require.NoError(t, err)
+
+	newRepo := &Repository{Enabled: true, URI: "http://new.example.com/debian", Distribution: "stable", Components: "main"}
+	diffs, err := PreviewAddRepository(newRepo, "etc/apt", WithFS(fsys))
+	require.NoError(t, err)
+	require.Equal(t, []*FileDiff{{
+		Path:    "etc/apt/sources.list.d/managed.list",
+		Created: true,
+		Diff: "--- /dev/null\n" +
+			"+++ etc/apt/sources.list.d/managed.list\n" +
+			"@@ -0,0 +1 @@\n" +
+			"+deb http://new.example.com/debian stable main\n",
+	}}, diffs)
+
+	edited := *repos[1]
+	edited.Distribution = "trixie"
+	diffs, err = PreviewEditRepository(repos[1], &edited, "etc/apt", WithFS(fsys))
+	require.NoError(t, err)
+	require.Len(t, diffs, 1)
+	require.Equal(t, "--- etc/apt/sources.list\n"+
+		"+++ etc/apt/sources.list\n"+
+		"@@ -1,3 +1,3 @@\n"+
+		" deb http://example.com/debian stable main\n"+
+		"-deb http://example.com/debian testing main\n"+
+		"+deb http://example.com/debian trixie main\n"+
+		" deb http://example.com/debian unstable main\n", diffs[0].Diff)
+
+	diffs, err = PreviewRemoveRepository(repos[5], "etc/apt", WithFS(fsys))
+	require.NoError(t, err)
+	require.Len(t, diffs, 1)
+	require.Equal(t, "etc/apt/sources.list.d/ubuntu.sources", diffs[0].Path)
+	require.False(t, diffs[0].Created)
+	require.Equal(t, "--- etc/apt/sources.list.d/ubuntu.sources\n"+
+		"+++ etc/apt/sources.list.d/ubuntu.sources\n"+
+		"@@ -1,4 +1,4 @@\n"+
+		"-Types: deb deb-src\n"+
+		"+Types: deb\n"+
+		" URIs: http://example.com/ubuntu\n"+
+		" Suites: noble\n"+
+		" Components: main\n", diffs[0].Diff)
+
+	diffs, err = PreviewRemoveRepository(repos[3], "etc/apt", WithFS(fsys))
+	require.NoError(t, err)
+	require.Equal(t, []*FileDiff{{
+		Path:    "etc/apt/sources.list.d/other.list",
+		Deleted: true,
+		Diff: "--- etc/apt/sources.list.d/other.list\n" +
+			"+++ /dev/null\n" +
+			"@@ -1 +0,0 @@\n" +
+			"-deb http://other.example.com/debian stable main\n",
+	}}, diffs)
+
+	_, err = PreviewRemoveRepository(newRepo, "etc/apt", WithFS(fsys))
+	require.EqualError(t, err, "repository already removed")
+
+	// Nothing is written
+	require.Equal(t, txSourcesList, readFSFile(t, fsys, "etc/apt/sources.list"))
+	require.Equal(t, txSources, readFSFile(t, fsys, "etc/apt/sources.list.d/ubuntu.sources"))
+	_, err = fs.Stat(fsys, "etc/apt/sources.list.d/managed.list")
+	require.True(t, os.IsNotExist(err))
+
+	// A read-only file system can be used
+	diffs, err = PreviewAddRepository(newRepo, "apt", WithFS(os.DirFS("testdata")))
+	require.NoError(t, err)
+	require.Len(t, diffs, 1)
+}
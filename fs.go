@@ -0,0 +1,335 @@
+//
+//  This file is part of go-apt-client library
+//
+//  Copyright (C) 2017  Arduino AG (http://www.arduino.cc/)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+//
+
+package apt
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing/fstest"
+	"time"
+)
+
+// WritableFS is a file system that, besides reading, allows the changes
+// needed to edit the APT configuration. Paths follow the fs.FS rules.
+type WritableFS interface {
+	fs.FS
+	// WriteFile writes data to the named file, creating it with
+	// permissions perm if necessary
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+	// Rename renames (moves) oldname to newname, replacing newname
+	// if it already exists
+	Rename(oldname, newname string) error
+	// Remove removes the named file or empty directory
+	Remove(name string) error
+	// MkdirAll creates the named directory and all the missing parents
+	MkdirAll(name string, perm fs.FileMode) error
+}
+
+// Option is an option of the functions that read or change the APT
+// configuration
+type Option func(*config)
+
+// WithFS makes the functions operate on the file system fsys instead of
+// the real disk. The config folder paths are interpreted inside fsys,
+// and the keyrings are referenced in the repositories options by their
+// absolute path from the root of fsys (like "/etc/apt/keyrings/x.gpg").
+// To change the configuration fsys must implement WritableFS.
+func WithFS(fsys fs.FS) Option {
+	return func(c *config) {
+		c.fs = fsys
+	}
+}
+
+type config struct {
+	fs           fs.FS
+	lockFile     string
+	lockTimeout  time.Duration
+	targetFile   string
+	fileHeader   string
+	launchpadAPI string
+	backups      int
+	overlapCheck bool
+
+	reenableUpgradeDisabled bool
+}
+
+func newConfig(opts []Option) *config {
+	res := &config{fs: osFS{}, backups: 1}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
+}
+
+// writableFS returns the file system, if it can be changed
+func (c *config) writableFS() (WritableFS, error) {
+	fsys, ok := c.fs.(WritableFS)
+	if !ok {
+		return nil, fmt.Errorf("the file system is read-only")
+	}
+	return fsys, nil
+}
+
+// absPath returns the absolute path, as seen by apt, of the file name
+func (c *config) absPath(name string) (string, error) {
+	if _, ok := c.fs.(osFS); ok {
+		return filepath.Abs(name)
+	}
+	return "/" + path.Clean(name), nil
+}
+
+// fsPath returns the path in the file system of the file with the
+// absolute path name, the opposite of absPath
+func (c *config) fsPath(name string) string {
+	if _, ok := c.fs.(osFS); ok {
+		return name
+	}
+	return strings.TrimPrefix(path.Clean(name), "/")
+}
+
+// durableFS is implemented by the file systems that can preserve the
+// metadata of the replaced files and flush the changes to disk
+type durableFS interface {
+	// copyMetadata copies owner, permissions and extended attributes of
+	// the file src to the file dst
+	copyMetadata(src, dst string) error
+	// syncDir flushes to disk the changes of the folder entries
+	syncDir(name string) error
+	// link creates newname as a hard link to the file oldname
+	link(oldname, newname string) error
+}
+
+// touchFS is implemented by the file systems that can set the
+// modification time of a file
+type touchFS interface {
+	// touch sets the modification time of the file to the current time
+	touch(name string) error
+}
+
+// osFS is the WritableFS of the real disk, it accepts native paths
+// (relative to the current directory or absolute)
+type osFS struct{}
+
+func (osFS) Open(name string) (fs.File, error) {
+	return os.Open(name)
+}
+
+func (osFS) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+func (osFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (osFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// WriteFile writes the file and flushes it to disk
+func (osFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (osFS) Rename(oldname, newname string) error {
+	return os.Rename(oldname, newname)
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) MkdirAll(name string, perm fs.FileMode) error {
+	return os.MkdirAll(name, perm)
+}
+
+func (osFS) link(oldname, newname string) error {
+	return os.Link(oldname, newname)
+}
+
+func (osFS) touch(name string) error {
+	now := time.Now()
+	return os.Chtimes(name, now, now)
+}
+
+func (osFS) syncDir(name string) error {
+	d, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// MemFS is an in-memory WritableFS, useful for tests or to prepare a
+// configuration without touching the disk. It's safe for concurrent use.
+type MemFS struct {
+	mu    sync.Mutex
+	files fstest.MapFS
+}
+
+// NewMemFS returns a MemFS that contains the given files, the keys are
+// the paths and the values the content. The parent directories are
+// created as needed.
+func NewMemFS(files map[string]string) *MemFS {
+	res := &MemFS{files: fstest.MapFS{}}
+	for name, data := range files {
+		res.files[name] = &fstest.MapFile{Data: []byte(data), Mode: 0644, ModTime: time.Now()}
+	}
+	return res
+}
+
+// Open implements fs.FS
+func (m *MemFS) Open(name string) (fs.File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.files.Open(name)
+}
+
+// ReadFile implements fs.ReadFileFS
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.files.ReadFile(name)
+}
+
+// ReadDir implements fs.ReadDirFS
+func (m *MemFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.files.ReadDir(name)
+}
+
+// Stat implements fs.StatFS
+func (m *MemFS) Stat(name string) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.files.Stat(name)
+}
+
+// WriteFile implements WritableFS
+func (m *MemFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkParent("write", name); err != nil {
+		return err
+	}
+	if info, err := m.files.Stat(name); err == nil && info.IsDir() {
+		return &fs.PathError{Op: "write", Path: name, Err: fmt.Errorf("is a directory")}
+	}
+	mode := perm & fs.ModePerm
+	if f, ok := m.files[name]; ok {
+		// Like os.WriteFile, the permissions of existing files are kept
+		mode = f.Mode
+	}
+	m.files[name] = &fstest.MapFile{Data: append([]byte{}, data...), Mode: mode, ModTime: time.Now()}
+	return nil
+}
+
+// Rename implements WritableFS, only files can be renamed
+func (m *MemFS) Rename(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.files[oldname]
+	if !ok || f.Mode.IsDir() {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: fs.ErrNotExist}
+	}
+	if err := m.checkParent("rename", newname); err != nil {
+		return err
+	}
+	delete(m.files, oldname)
+	m.files[newname] = f
+	return nil
+}
+
+func (m *MemFS) touch(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.files[name]
+	if !ok {
+		return &fs.PathError{Op: "touch", Path: name, Err: fs.ErrNotExist}
+	}
+	f.ModTime = time.Now()
+	return nil
+}
+
+// Remove implements WritableFS
+func (m *MemFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	info, err := m.files.Stat(name)
+	if err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	if info.IsDir() {
+		if entries, _ := m.files.ReadDir(name); len(entries) > 0 {
+			return &fs.PathError{Op: "remove", Path: name, Err: fmt.Errorf("directory not empty")}
+		}
+	}
+	delete(m.files, name)
+	return nil
+}
+
+// MkdirAll implements WritableFS
+func (m *MemFS) MkdirAll(name string, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrInvalid}
+	}
+	for dir := name; dir != "."; dir = path.Dir(dir) {
+		info, err := m.files.Stat(dir)
+		if err == nil && !info.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: dir, Err: fmt.Errorf("not a directory")}
+		}
+		if err != nil {
+			m.files[dir] = &fstest.MapFile{Mode: fs.ModeDir | perm&fs.ModePerm, ModTime: time.Now()}
+		}
+	}
+	return nil
+}
+
+// checkParent checks that the name is valid and that its parent
+// directory exists
+func (m *MemFS) checkParent(op, name string) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if info, err := m.files.Stat(path.Dir(name)); err != nil || !info.IsDir() {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return nil
+}
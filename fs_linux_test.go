@@ -0,0 +1,62 @@
+//
+//  This file is part of go-apt-client library
+//
+//  Copyright (C) 2017  Arduino AG (http://www.arduino.cc/)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+//
+
+//go:build linux
+
+package apt
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplacePreservesOwnerAndXattrs(t *testing.T) {
+	folder := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(folder, "sources.list.d"), 0755))
+	sourcesList := filepath.Join(folder, "sources.list")
+	require.NoError(t, os.WriteFile(sourcesList, []byte("deb http://example.com/debian stable main\n"), 0644))
+	err := syscall.Setxattr(sourcesList, "user.go-apt-client", []byte("test"), 0)
+	if errors.Is(err, syscall.ENOTSUP) {
+		t.Skip("extended attributes are not supported")
+	}
+	require.NoError(t, err)
+	uid, gid := os.Getuid(), os.Getgid()
+	if uid == 0 {
+		uid, gid = 1234, 5678
+		require.NoError(t, os.Chown(sourcesList, uid, gid))
+	}
+
+	repos, err := ParseAPTConfigFolder(folder)
+	require.NoError(t, err)
+	require.NoError(t, RemoveRepository(repos[0], folder))
+
+	info, err := os.Stat(sourcesList)
+	require.NoError(t, err)
+	st := info.Sys().(*syscall.Stat_t)
+	require.Equal(t, uid, int(st.Uid))
+	require.Equal(t, gid, int(st.Gid))
+	value := make([]byte, 16)
+	n, err := syscall.Getxattr(sourcesList, "user.go-apt-client", value)
+	require.NoError(t, err)
+	require.Equal(t, "test", string(value[:n]))
+}
@@ -0,0 +1,182 @@
+//
+//  This file is part of go-apt-client library
+//
+//  Copyright (C) 2017  Arduino AG (http://www.arduino.cc/)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+//
+
+package apt
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newMemFSFromDir returns a MemFS with a copy of the files in dir placed
+// in the root folder
+func newMemFSFromDir(t *testing.T, dir string, root string) *MemFS {
+	res := NewMemFS(nil)
+	require.NoError(t, res.MkdirAll(root, 0755))
+	err := fs.WalkDir(os.DirFS(dir), ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return res.MkdirAll(path.Join(root, name), 0755)
+		}
+		data, err := fs.ReadFile(os.DirFS(dir), name)
+		if err != nil {
+			return err
+		}
+		return res.WriteFile(path.Join(root, name), data, 0644)
+	})
+	require.NoError(t, err)
+	return res
+}
+
+func TestMemFS(t *testing.T) {
+	fsys := NewMemFS(map[string]string{"etc/apt/sources.list": "deb http://example.com/debian stable main\n"})
+	require.NoError(t, fstest.TestFS(fsys, "etc/apt/sources.list"))
+
+	require.NoError(t, fsys.WriteFile("etc/apt/new.list", []byte("a"), 0600))
+	require.Error(t, fsys.WriteFile("etc/missing/new.list", []byte("a"), 0600))
+	require.Error(t, fsys.WriteFile("/etc/apt/new.list", []byte("a"), 0600))
+	info, err := fs.Stat(fsys, "etc/apt/new.list")
+	require.NoError(t, err)
+	require.Equal(t, fs.FileMode(0600), info.Mode())
+
+	require.NoError(t, fsys.Rename("etc/apt/new.list", "etc/apt/sources.list"))
+	data, err := fs.ReadFile(fsys, "etc/apt/sources.list")
+	require.NoError(t, err)
+	require.Equal(t, "a", string(data))
+	_, err = fs.Stat(fsys, "etc/apt/new.list")
+	require.True(t, os.IsNotExist(err))
+	require.True(t, os.IsNotExist(fsys.Rename("etc/apt/new.list", "etc/apt/other.list")))
+
+	require.NoError(t, fsys.MkdirAll("etc/apt/sources.list.d", 0755))
+	require.Error(t, fsys.MkdirAll("etc/apt/sources.list/x", 0755))
+	require.Error(t, fsys.Remove("etc/apt"))
+	require.NoError(t, fsys.Remove("etc/apt/sources.list.d"))
+	require.True(t, os.IsNotExist(fsys.Remove("etc/apt/sources.list.d")))
+}
+
+func TestRepositoriesInMemFS(t *testing.T) {
+	fsys := newMemFSFromDir(t, "testdata/apt", "etc/apt")
+	expected, err := ParseAPTConfigFolder("testdata/apt")
+	require.NoError(t, err)
+	repos, err := ParseAPTConfigFolder("etc/apt", WithFS(fsys))
+	require.NoError(t, err)
+	require.Len(t, repos, len(expected))
+	require.Equal(t, "etc/apt/sources.list", repos[0].File)
+
+	// Keyrings are referenced by their absolute path in the file system
+	key, err := os.ReadFile("testdata/keys/example.asc")
+	require.NoError(t, err)
+	repo := &Repository{Enabled: true, URI: "https://repo.example.com/debian", Distribution: "stable", Components: "main"}
+	added, err := AddRepositoryWithKey(repo, "example", key, "etc/apt", WithFS(fsys))
+	require.NoError(t, err)
+	require.Equal(t, []string{"/etc/apt/keyrings/example.gpg"}, added.Options.SignedBy)
+	require.Empty(t, repo.Options.SignedBy)
+	_, err = fs.Stat(fsys, "etc/apt/keyrings/example.gpg")
+	require.NoError(t, err)
+	data, err := fs.ReadFile(fsys, "etc/apt/sources.list.d/managed.list")
+	require.NoError(t, err)
+	require.Equal(t, "deb [signed-by=/etc/apt/keyrings/example.gpg] https://repo.example.com/debian stable main\n", string(data))
+
+	require.NoError(t, RemoveRepository(added, "etc/apt", WithFS(fsys)))
+	_, err = fs.Stat(fsys, "etc/apt/keyrings/example.gpg")
+	require.True(t, os.IsNotExist(err))
+
+	// The disk is never touched
+	_, err = os.Stat("testdata/apt/sources.list.d/managed.list")
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestReadOnlyFS(t *testing.T) {
+	fsys := os.DirFS("testdata")
+	repos, err := ParseAPTConfigFolder("apt", WithFS(fsys))
+	require.NoError(t, err)
+	require.NotEmpty(t, repos)
+
+	repo := &Repository{Enabled: true, URI: "https://repo.example.com/debian", Distribution: "stable", Components: "main"}
+	require.EqualError(t, AddRepository(repo, "apt", WithFS(fsys)), "the file system is read-only")
+	require.EqualError(t, RemoveRepository(repos[0], "apt", WithFS(fsys)), "the file system is read-only")
+	require.EqualError(t, EditRepository(repos[0], repo, "apt", WithFS(fsys)), "the file system is read-only")
+}
+
+func TestReplacePreservesPermissions(t *testing.T) {
+	folder := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(folder, "sources.list.d"), 0755))
+	sourcesList := filepath.Join(folder, "sources.list")
+	require.NoError(t, os.WriteFile(sourcesList, []byte("deb http://example.com/debian stable main\n"), 0644))
+	require.NoError(t, os.Chmod(sourcesList, 0604))
+
+	repos, err := ParseAPTConfigFolder(folder)
+	require.NoError(t, err)
+	edited := *repos[0]
+	edited.Distribution = "testing"
+	require.NoError(t, EditRepository(repos[0], &edited, folder))
+
+	info, err := os.Stat(sourcesList)
+	require.NoError(t, err)
+	require.Equal(t, fs.FileMode(0604), info.Mode().Perm())
+	data, err := os.ReadFile(sourcesList)
+	require.NoError(t, err)
+	require.Equal(t, "deb http://example.com/debian testing main\n", string(data))
+
+	// The backup is the previous version, not the replaced file
+	data, err = os.ReadFile(sourcesList + ".save")
+	require.NoError(t, err)
+	require.Equal(t, "deb http://example.com/debian stable main\n", string(data))
+	require.NoFileExists(t, sourcesList+".orig")
+}
+
+// watchFS is a MemFS that checks that a file always exists while it's
+// being replaced
+type watchFS struct {
+	*MemFS
+	t    *testing.T
+	path string
+}
+
+func (w *watchFS) Rename(oldname, newname string) error {
+	err := w.MemFS.Rename(oldname, newname)
+	_, statErr := w.MemFS.Stat(w.path)
+	require.NoError(w.t, statErr, "%s missing after renaming %s to %s", w.path, oldname, newname)
+	return err
+}
+
+func (w *watchFS) Remove(name string) error {
+	err := w.MemFS.Remove(name)
+	_, statErr := w.MemFS.Stat(w.path)
+	require.NoError(w.t, statErr, "%s missing after removing %s", w.path, name)
+	return err
+}
+
+func TestReplaceNeverRemovesFile(t *testing.T) {
+	fsys := &watchFS{MemFS: newTransactionTestFS(), t: t, path: "etc/apt/sources.list"}
+	repos, err := ParseAPTConfigFolder("etc/apt", WithFS(fsys))
+	require.NoError(t, err)
+	edited := *repos[0]
+	edited.Distribution = "oldstable"
+	require.NoError(t, EditRepository(repos[0], &edited, "etc/apt", WithFS(fsys)))
+	require.NoError(t, EditRepository(&edited, repos[0], "etc/apt", WithFS(fsys), WithBackups(0)))
+	require.Equal(t, txSourcesList, readFSFile(t, fsys, "etc/apt/sources.list"))
+}
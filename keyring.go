@@ -0,0 +1,168 @@
+//
+//  This file is part of go-apt-client library
+//
+//  Copyright (C) 2017  Arduino AG (http://www.arduino.cc/)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+//
+
+package apt
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var keyringNameRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.+-]*$`)
+
+// keyringsFolder returns the absolute path of the folder that contains
+// the repositories keyrings (usually /etc/apt/keyrings)
+func keyringsFolder(configFolderPath string, c *config) (string, error) {
+	return c.absPath(filepath.Join(configFolderPath, "keyrings"))
+}
+
+// InstallKeyring stores the OpenPGP public key (either ASCII-armored or
+// binary) as a dedicated keyring named "<name>.gpg" in the "keyrings"
+// subfolder of the specified APT config folder (usually /etc/apt), and
+// returns the absolute path of the keyring, ready to be used in the
+// "signed-by" option of a Repository.
+func InstallKeyring(name string, key []byte, configFolderPath string, opts ...Option) (string, error) {
+	c := newConfig(opts)
+	unlock, err := c.lock(configFolderPath)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	return installKeyring(name, key, configFolderPath, c)
+}
+
+func installKeyring(name string, key []byte, configFolderPath string, c *config) (string, error) {
+	fsys, err := c.writableFS()
+	if err != nil {
+		return "", err
+	}
+	name = strings.TrimSuffix(name, ".gpg")
+	if !keyringNameRegexp.MatchString(name) {
+		return "", fmt.Errorf("invalid keyring name '%s'", name)
+	}
+	keys, err := parseOpenPGPKeys(key)
+	if err != nil {
+		return "", fmt.Errorf("parsing key: %s", err)
+	}
+	if len(keys) == 0 {
+		return "", fmt.Errorf("parsing key: no supported public key found")
+	}
+	keyring := []byte{}
+	for _, k := range keys {
+		keyring = append(keyring, k.data...)
+	}
+
+	folder, err := keyringsFolder(configFolderPath, c)
+	if err != nil {
+		return "", fmt.Errorf("getting keyrings folder: %s", err)
+	}
+	if err := fsys.MkdirAll(c.fsPath(folder), 0755); err != nil {
+		return "", fmt.Errorf("creating keyrings folder: %s", err)
+	}
+	keyringPath := filepath.Join(folder, name+".gpg")
+	if current, err := fs.ReadFile(fsys, c.fsPath(keyringPath)); err == nil {
+		if !bytes.Equal(current, keyring) {
+			return "", fmt.Errorf("a different keyring named %s already exists", keyringPath)
+		}
+		return keyringPath, nil
+	}
+	if err := fsys.WriteFile(c.fsPath(keyringPath), keyring, 0644); err != nil {
+		return "", fmt.Errorf("writing keyring: %s", err)
+	}
+	return keyringPath, nil
+}
+
+// AddRepositoryWithKey adds the specified repository, like AddRepository,
+// together with its signing key. The key is installed as the keyring named
+// keyringName (see InstallKeyring) and the repository is added with the
+// "signed-by" option pointing to it: the added repository is returned,
+// repo is left unchanged. The keyring is removed by RemoveRepository once
+// no more repositories reference it.
+func AddRepositoryWithKey(repo *Repository, keyringName string, key []byte, configFolderPath string, opts ...Option) (*Repository, error) {
+	c := newConfig(opts)
+	unlock, err := c.lock(configFolderPath)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	fsys, err := c.writableFS()
+	if err != nil {
+		return nil, err
+	}
+	folder, err := keyringsFolder(configFolderPath, c)
+	if err != nil {
+		return nil, fmt.Errorf("getting keyrings folder: %s", err)
+	}
+	_, statErr := fs.Stat(fsys, c.fsPath(filepath.Join(folder, strings.TrimSuffix(keyringName, ".gpg")+".gpg")))
+	keyringPath, err := installKeyring(keyringName, key, configFolderPath, c)
+	if err != nil {
+		return nil, fmt.Errorf("installing keyring: %s", err)
+	}
+	res := *repo
+	res.Options.SignedBy = []string{keyringPath}
+	tx := newTransaction(configFolderPath, c)
+	tx.AddRepository(&res)
+	if err := tx.commit(); err != nil {
+		if os.IsNotExist(statErr) {
+			// Remove the keyring only if it has been just created
+			_ = fsys.Remove(c.fsPath(keyringPath))
+		}
+		return nil, err
+	}
+	return &res, nil
+}
+
+// removeUnusedKeyrings deletes the keyrings, between the ones specified,
+// that are stored in the keyrings folder and are no longer referenced
+// by any repository.
+func removeUnusedKeyrings(keyrings []string, configFolderPath string, c *config) error {
+	fsys, err := c.writableFS()
+	if err != nil {
+		return err
+	}
+	folder, err := keyringsFolder(configFolderPath, c)
+	if err != nil {
+		return fmt.Errorf("getting keyrings folder: %s", err)
+	}
+	repos, _, err := parseAPTConfigFolder(configFolderPath, ParseLenient, c)
+	if err != nil {
+		return fmt.Errorf("parsing APT config: %s", err)
+	}
+	used := []string{}
+	for _, repo := range repos {
+		for _, k := range repo.Options.SignedBy {
+			used = append(used, filepath.Clean(k))
+		}
+	}
+	for _, k := range keyrings {
+		k = filepath.Clean(k)
+		if filepath.Dir(k) != folder || slices.Contains(used, k) {
+			continue
+		}
+		if err := fsys.Remove(c.fsPath(k)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("removing unused keyring %s: %s", k, err)
+		}
+	}
+	return nil
+}
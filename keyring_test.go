@@ -0,0 +1,127 @@
+//
+//  This file is part of go-apt-client library
+//
+//  Copyright (C) 2017  Arduino AG (http://www.arduino.cc/)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+//
+
+package apt
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOpenPGPKeys(t *testing.T) {
+	binary, err := os.ReadFile("testdata/keys/example.gpg")
+	require.NoError(t, err)
+	armored, err := os.ReadFile("testdata/keys/example.asc")
+	require.NoError(t, err)
+
+	keys, err := parseOpenPGPKeys(binary)
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	require.Equal(t, "EBA0D3A6C35970FD0A7C365E92D00A1EB4F40D37", keys[0].Fingerprint)
+	require.Equal(t, []string{"A030CABD5E68CEBCED09676358298C39607F3BC5"}, keys[0].SubkeyFingerprint)
+	require.Equal(t, []string{"Example Repository <repo@example.com>"}, keys[0].UserIDs)
+	require.Equal(t, binary, keys[0].data)
+
+	dearmored, err := dearmor(armored, armorPublicKey)
+	require.NoError(t, err)
+	require.Equal(t, binary, dearmored)
+
+	other, err := os.ReadFile("testdata/keys/other.gpg")
+	require.NoError(t, err)
+	keys, err = parseOpenPGPKeys(append(binary, other...))
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	require.Equal(t, "497CFEEA85BC319FB478B32DCB4A649E3FF5CF3F", keys[1].Fingerprint)
+
+	// A v3 key, with its user ID, is skipped
+	v3 := []byte{0x98, 14, 3, 0, 0, 0, 0, 0, 0, 1, 0, 8, 0xff, 0, 2, 3}
+	v3 = append(v3, 0xb4, 4, 'o', 'l', 'd', '!')
+	keys, err = parseOpenPGPKeys(append(append(slices.Clone(v3), binary...), v3...))
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	require.Equal(t, "EBA0D3A6C35970FD0A7C365E92D00A1EB4F40D37", keys[0].Fingerprint)
+	require.Equal(t, binary, keys[0].data)
+	keys, err = parseOpenPGPKeys(v3)
+	require.NoError(t, err)
+	require.Empty(t, keys)
+	_, err = installKeyring("old", v3, t.TempDir(), newConfig(nil))
+	require.EqualError(t, err, "parsing key: no supported public key found")
+
+	_, err = parseOpenPGPKeys([]byte("not a key"))
+	require.Error(t, err)
+	_, err = parseOpenPGPKeys(binary[:100])
+	require.Error(t, err)
+}
+
+func TestAddRepositoryWithKey(t *testing.T) {
+	folder, _ := setupSourcesListConfigFolder(t)
+	armored, err := os.ReadFile("testdata/keys/example.asc")
+	require.NoError(t, err)
+	binary, err := os.ReadFile("testdata/keys/example.gpg")
+	require.NoError(t, err)
+
+	repo1 := &Repository{Enabled: true, URI: "https://example.com/debian", Distribution: "stable", Components: "main"}
+	repo2 := &Repository{Enabled: true, URI: "https://example.com/debian", Distribution: "testing", Components: "main"}
+	repo1, err = AddRepositoryWithKey(repo1, "example", armored, folder)
+	require.NoError(t, err)
+	repo2, err = AddRepositoryWithKey(repo2, "example.gpg", binary, folder)
+	require.NoError(t, err)
+
+	keyring, err := filepath.Abs(filepath.Join(folder, "keyrings", "example.gpg"))
+	require.NoError(t, err)
+	require.Equal(t, []string{keyring}, repo1.Options.SignedBy)
+	data, err := os.ReadFile(keyring)
+	require.NoError(t, err)
+	require.Equal(t, binary, data)
+
+	repos, err := ParseAPTConfigFolder(folder)
+	require.NoError(t, err)
+	require.True(t, repos.Contains(repo1))
+	require.True(t, repos.Contains(repo2))
+
+	// A different key can't replace an existing keyring
+	other, err := os.ReadFile("testdata/keys/other.gpg")
+	require.NoError(t, err)
+	repo3 := &Repository{Enabled: true, URI: "https://example.org/debian", Distribution: "stable", Components: "main"}
+	_, err = AddRepositoryWithKey(repo3, "example", other, folder)
+	require.Error(t, err)
+	require.Empty(t, repo3.Options.SignedBy)
+
+	// The repository is left unchanged if it can't be added
+	repo4 := &Repository{Enabled: true, URI: "https://example.com/debian", Distribution: "stable", Components: "main"}
+	_, err = AddRepositoryWithKey(repo4, "example", binary, folder)
+	require.EqualError(t, err, "the repository is already configured")
+	require.Empty(t, repo4.Options.SignedBy)
+	require.FileExists(t, keyring)
+
+	// The keyring is removed together with the last repository using it
+	require.NoError(t, RemoveRepository(repo1, folder))
+	require.FileExists(t, keyring)
+	require.NoError(t, RemoveRepository(repo2, folder))
+	require.NoFileExists(t, keyring)
+
+	// Invalid keys or names are rejected
+	_, err = AddRepositoryWithKey(repo3, "example", []byte("garbage"), folder)
+	require.Error(t, err)
+	_, err = AddRepositoryWithKey(repo3, "../example", binary, folder)
+	require.Error(t, err)
+}
@@ -0,0 +1,297 @@
+//
+//  This file is part of go-apt-client library
+//
+//  Copyright (C) 2017  Arduino AG (http://www.arduino.cc/)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+//
+
+package apt
+
+import (
+	"fmt"
+	"io/fs"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// LegacyKey is a key stored in one of the legacy apt-key keyrings
+// ("trusted.gpg" or the files in "trusted.gpg.d")
+type LegacyKey struct {
+	Fingerprint string
+	UserIDs     []string
+	Keyring     string
+
+	key *openpgpKey
+}
+
+// KeyMigration describes the migration of a legacy key to a dedicated
+// keyring used by one or more repositories
+type KeyMigration struct {
+	Key *LegacyKey
+	// NewKeyring is the path of the keyring that contains only Key
+	NewKeyring string
+	// Repositories are the repositories that are signed by Key and that
+	// will be changed to use NewKeyring in their "signed-by" option
+	Repositories RepositoryList
+}
+
+// KeyMigrationReport is the result of MigrateLegacyKeys
+type KeyMigrationReport struct {
+	Migrations []*KeyMigration
+	// UnmatchedKeys are the legacy keys that doesn't sign any repository
+	UnmatchedKeys []*LegacyKey
+	// UnmatchedRepositories are the repositories without "signed-by"
+	// option for which no legacy key was found
+	UnmatchedRepositories RepositoryList
+}
+
+// String returns a human-readable description of the migration
+func (r *KeyMigrationReport) String() string {
+	res := ""
+	for _, m := range r.Migrations {
+		res += fmt.Sprintf("key %s (%s) from %s -> %s\n", m.Key.Fingerprint, strings.Join(m.Key.UserIDs, ", "), m.Key.Keyring, m.NewKeyring)
+		for _, repo := range m.Repositories {
+			res += "  " + repo.String() + "\n"
+		}
+	}
+	for _, k := range r.UnmatchedKeys {
+		res += fmt.Sprintf("key %s (%s) from %s: no matching repository\n", k.Fingerprint, strings.Join(k.UserIDs, ", "), k.Keyring)
+	}
+	for _, repo := range r.UnmatchedRepositories {
+		res += "no key found for: " + repo.String() + "\n"
+	}
+	return res
+}
+
+// ListLegacyKeys returns all the keys stored in the legacy apt-key keyrings
+// of the specified APT config folder (usually /etc/apt), that is the
+// "trusted.gpg" file and the "*.gpg" and "*.asc" files in "trusted.gpg.d".
+func ListLegacyKeys(configFolderPath string, opts ...Option) ([]*LegacyKey, error) {
+	c := newConfig(opts)
+	keyrings := []string{filepath.Join(configFolderPath, "trusted.gpg")}
+	trustedFolder := filepath.Join(configFolderPath, "trusted.gpg.d")
+	list, err := fs.ReadDir(c.fs, trustedFolder)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading %s folder: %s", trustedFolder, err)
+	}
+	for _, l := range list {
+		if strings.HasSuffix(l.Name(), ".gpg") || strings.HasSuffix(l.Name(), ".asc") {
+			keyrings = append(keyrings, filepath.Join(trustedFolder, l.Name()))
+		}
+	}
+
+	res := []*LegacyKey{}
+	for _, keyring := range keyrings {
+		data, err := fs.ReadFile(c.fs, keyring)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading keyring %s: %s", keyring, err)
+		}
+		if len(data) == 0 {
+			continue
+		}
+		keys, err := parseOpenPGPKeys(data)
+		if err != nil {
+			return nil, fmt.Errorf("parsing keyring %s: %s", keyring, err)
+		}
+		for _, k := range keys {
+			res = append(res, &LegacyKey{
+				Fingerprint: k.Fingerprint,
+				UserIDs:     k.UserIDs,
+				Keyring:     keyring,
+				key:         k,
+			})
+		}
+	}
+	return res, nil
+}
+
+// MigrateLegacyKeys moves the keys of the legacy apt-key keyrings to
+// dedicated keyrings, one for each key, and changes the repositories
+// signed by them to use the new keyrings through the "signed-by" option.
+// Only the repositories without a "signed-by" option are considered.
+//
+// A key is matched to a repository if it made the signature of the
+// repository InRelease (or Release.gpg) file, as downloaded by apt in the
+// listsFolderPath folder (usually /var/lib/apt/lists), or, if the file is
+// not available, if the domain of the e-mail in its user IDs matches the
+// repository host. listsFolderPath may be empty to skip the first check,
+// if WithFS is used it is a path inside the same file system.
+//
+// If dryRun is true nothing is changed and the returned report describes
+// what would be done. The legacy keyrings are never modified.
+func MigrateLegacyKeys(configFolderPath string, listsFolderPath string, dryRun bool, opts ...Option) (*KeyMigrationReport, error) {
+	c := newConfig(opts)
+	if !dryRun {
+		unlock, err := c.lock(configFolderPath)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+	keys, err := ListLegacyKeys(configFolderPath, opts...)
+	if err != nil {
+		return nil, err
+	}
+	repos, _, err := parseAPTConfigFolder(configFolderPath, ParseLenient, c)
+	if err != nil {
+		return nil, fmt.Errorf("parsing APT config: %s", err)
+	}
+	keyringsFolder, err := keyringsFolder(configFolderPath, c)
+	if err != nil {
+		return nil, fmt.Errorf("getting keyrings folder: %s", err)
+	}
+
+	report := &KeyMigrationReport{}
+	migrations := map[*LegacyKey]*KeyMigration{}
+	for _, repo := range repos {
+		if len(repo.Options.SignedBy) > 0 {
+			continue
+		}
+		matches := []*LegacyKey{}
+		if issuers := repositoryIssuers(c.fs, repo, listsFolderPath); len(issuers) > 0 {
+			for _, k := range keys {
+				if slices.ContainsFunc(issuers, k.key.matchesKeyID) {
+					matches = append(matches, k)
+				}
+			}
+		} else {
+			for _, k := range keys {
+				if keyMatchesHost(k, repo.URI) {
+					matches = append(matches, k)
+				}
+			}
+		}
+		if len(matches) == 0 {
+			report.UnmatchedRepositories = append(report.UnmatchedRepositories, repo)
+			continue
+		}
+		for _, k := range matches {
+			m, ok := migrations[k]
+			if !ok {
+				m = &KeyMigration{
+					Key:        k,
+					NewKeyring: filepath.Join(keyringsFolder, legacyKeyringName(k, repo)+".gpg"),
+				}
+				migrations[k] = m
+			}
+			m.Repositories = append(m.Repositories, repo)
+		}
+	}
+	for _, k := range keys {
+		if m, ok := migrations[k]; ok {
+			report.Migrations = append(report.Migrations, m)
+		} else {
+			report.UnmatchedKeys = append(report.UnmatchedKeys, k)
+		}
+	}
+	if dryRun {
+		return report, nil
+	}
+
+	for _, m := range report.Migrations {
+		if _, err := installKeyring(filepath.Base(m.NewKeyring), m.Key.key.data, configFolderPath, c); err != nil {
+			return report, fmt.Errorf("installing keyring for key %s: %s", m.Key.Fingerprint, err)
+		}
+	}
+	tx := newTransaction(configFolderPath, c)
+	for _, repo := range repos {
+		newRepo := *repo
+		for _, m := range report.Migrations {
+			if m.Repositories.Contains(repo) && !slices.Contains(newRepo.Options.SignedBy, m.NewKeyring) {
+				newRepo.Options.SignedBy = append(slices.Clone(newRepo.Options.SignedBy), m.NewKeyring)
+			}
+		}
+		if len(newRepo.Options.SignedBy) != len(repo.Options.SignedBy) {
+			tx.EditRepository(repo, &newRepo)
+		}
+	}
+	if err := tx.commit(); err != nil {
+		return report, fmt.Errorf("editing repositories: %s", err)
+	}
+	return report, nil
+}
+
+// legacyKeyringName returns the name of the dedicated keyring for the key
+func legacyKeyringName(k *LegacyKey, repo *Repository) string {
+	name := "legacy"
+	if u, err := url.Parse(repo.URI); err == nil && u.Hostname() != "" {
+		name = u.Hostname()
+	}
+	return name + "-" + k.Fingerprint[len(k.Fingerprint)-8:]
+}
+
+// repositoryIssuers returns the issuers of the signatures of the Release
+// files of the repository, as downloaded by apt in the lists folder
+func repositoryIssuers(fsys fs.FS, repo *Repository, listsFolderPath string) []string {
+	if listsFolderPath == "" {
+		return nil
+	}
+	prefix := aptListsFileName(strings.TrimSuffix(repo.URI, "/") + "/dists/" + repo.Distribution + "/")
+	for _, name := range []string{"InRelease", "Release.gpg"} {
+		data, err := fs.ReadFile(fsys, filepath.Join(listsFolderPath, prefix+name))
+		if err != nil {
+			continue
+		}
+		if issuers, err := signatureIssuers(data); err == nil && len(issuers) > 0 {
+			return issuers
+		}
+	}
+	return nil
+}
+
+// aptListsFileName converts an URI to the name of the file used by apt
+// to store it in the lists folder (like URItoFileName in apt sources).
+func aptListsFileName(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return ""
+	}
+	res := ""
+	for _, c := range []byte(u.Host + u.Path) {
+		if c <= 0x20 || c >= 0x7F || strings.IndexByte("\\|{}[]<>\"^~_=!@#$%^&*", c) != -1 {
+			res += fmt.Sprintf("%%%02X", c)
+			continue
+		}
+		res += string(c)
+	}
+	return strings.ReplaceAll(res, "/", "_")
+}
+
+// keyMatchesHost returns true if the domain of the e-mail address of one
+// of the user IDs of the key matches the host of the URI
+func keyMatchesHost(k *LegacyKey, uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, uid := range k.UserIDs {
+		addr, err := mail.ParseAddress(uid)
+		if err != nil {
+			continue
+		}
+		_, domain, _ := strings.Cut(strings.ToLower(addr.Address), "@")
+		if domain != "" && (host == domain || strings.HasSuffix(host, "."+domain)) {
+			return true
+		}
+	}
+	return false
+}
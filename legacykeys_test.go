@@ -0,0 +1,120 @@
+//
+//  This file is part of go-apt-client library
+//
+//  Copyright (C) 2017  Arduino AG (http://www.arduino.cc/)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+//
+
+package apt
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func copyTestFolder(t *testing.T, src string) string {
+	dst := t.TempDir()
+	err := filepath.WalkDir(src, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, rel), 0755)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dst, rel), data, 0644)
+	})
+	require.NoError(t, err)
+	return dst
+}
+
+func TestAPTListsFileName(t *testing.T) {
+	require.Equal(t, "deb.debian.org_debian_dists_bookworm_", aptListsFileName("http://deb.debian.org/debian/dists/bookworm/"))
+	require.Equal(t, "host:8080_a%7Eb_dists_stable_", aptListsFileName("https://user:pw@host:8080/a~b/dists/stable/"))
+}
+
+func TestMigrateLegacyKeys(t *testing.T) {
+	folder := copyTestFolder(t, "testdata/legacy-keys/apt")
+	lists := "testdata/legacy-keys/lists"
+	original, err := os.ReadFile(filepath.Join(folder, "sources.list"))
+	require.NoError(t, err)
+	// A keyring with only an old v3 key, that is skipped
+	v3 := []byte{0x98, 14, 3, 0, 0, 0, 0, 0, 0, 1, 0, 8, 0xff, 0, 2, 3}
+	require.NoError(t, os.WriteFile(filepath.Join(folder, "trusted.gpg.d", "old.gpg"), v3, 0644))
+
+	keys, err := ListLegacyKeys(folder)
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	require.Equal(t, "EBA0D3A6C35970FD0A7C365E92D00A1EB4F40D37", keys[0].Fingerprint)
+	require.Equal(t, filepath.Join(folder, "trusted.gpg"), keys[0].Keyring)
+	require.Equal(t, "497CFEEA85BC319FB478B32DCB4A649E3FF5CF3F", keys[1].Fingerprint)
+	require.Equal(t, filepath.Join(folder, "trusted.gpg.d", "other.asc"), keys[1].Keyring)
+
+	// Dry run
+	report, err := MigrateLegacyKeys(folder, lists, true)
+	require.NoError(t, err)
+	require.Len(t, report.Migrations, 2)
+	require.Empty(t, report.UnmatchedKeys)
+	require.Empty(t, report.UnmatchedRepositories)
+	keyrings, err := keyringsFolder(folder, newConfig(nil))
+	require.NoError(t, err)
+	example := filepath.Join(keyrings, "example.com-B4F40D37.gpg")
+	other := filepath.Join(keyrings, "repo.example.net-3FF5CF3F.gpg")
+	require.Equal(t, example, report.Migrations[0].NewKeyring)
+	require.Equal(t, "https://example.com/debian", report.Migrations[0].Repositories[0].URI)
+	require.Equal(t, other, report.Migrations[1].NewKeyring)
+	require.Equal(t, "http://repo.example.net/apt", report.Migrations[1].Repositories[0].URI)
+	require.Contains(t, report.String(), "key 497CFEEA85BC319FB478B32DCB4A649E3FF5CF3F (Other Repository <other@example.org>)")
+	data, err := os.ReadFile(filepath.Join(folder, "sources.list"))
+	require.NoError(t, err)
+	require.Equal(t, string(original), string(data))
+	require.NoDirExists(t, keyrings)
+
+	// Without the lists only the key matching the host is found
+	report, err = MigrateLegacyKeys(folder, "", true)
+	require.NoError(t, err)
+	require.Len(t, report.Migrations, 1)
+	require.Len(t, report.UnmatchedKeys, 1)
+	require.Len(t, report.UnmatchedRepositories, 1)
+
+	// Actual migration
+	_, err = MigrateLegacyKeys(folder, lists, false)
+	require.NoError(t, err)
+	data, err = os.ReadFile(filepath.Join(folder, "sources.list"))
+	require.NoError(t, err)
+	require.Equal(t, "deb [signed-by="+example+"] https://example.com/debian stable main\n"+
+		"deb [signed-by="+other+"] http://repo.example.net/apt stable main\n"+
+		"deb [signed-by=/usr/share/keyrings/debian-archive-keyring.gpg] http://deb.debian.org/debian bookworm main\n", string(data))
+	exampleKey, err := os.ReadFile("testdata/keys/example.gpg")
+	require.NoError(t, err)
+	data, err = os.ReadFile(example)
+	require.NoError(t, err)
+	require.Equal(t, exampleKey, data)
+	require.FileExists(t, other)
+
+	// Nothing left to migrate
+	report, err = MigrateLegacyKeys(folder, lists, true)
+	require.NoError(t, err)
+	require.Empty(t, report.Migrations)
+}
@@ -0,0 +1,288 @@
+//
+//  This file is part of go-apt-client library
+//
+//  Copyright (C) 2017  Arduino AG (http://www.arduino.cc/)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+//
+
+package apt
+
+import (
+	"cmp"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// LintCheck identifies a check made by Lint
+type LintCheck string
+
+const (
+	// LintSyntax reports malformed entries (see Diagnostic)
+	LintSyntax LintCheck = "syntax"
+	// LintDuplicate reports repositories configured more than once
+	LintDuplicate LintCheck = "duplicate"
+	// LintSuiteMismatch reports repositories for a release different from
+	// the installed one
+	LintSuiteMismatch LintCheck = "suite-mismatch"
+	// LintInsecureURI reports plain http or ftp repositories without a
+	// "signed-by" keyring
+	LintInsecureURI LintCheck = "insecure-uri"
+	// LintTrusted reports repositories with the "trusted=yes" option
+	LintTrusted LintCheck = "trusted"
+	// LintCredentials reports URIs that contain a password
+	LintCredentials LintCheck = "credentials"
+	// LintUpgradeLeftover reports the entries disabled by a release
+	// upgrade
+	LintUpgradeLeftover LintCheck = "upgrade-leftover"
+	// LintEmptyFile reports the source files without enabled entries
+	LintEmptyFile LintCheck = "empty-file"
+)
+
+// Finding is a problem of the APT configuration found by Lint
+type Finding struct {
+	Check    LintCheck
+	Severity Severity
+	File     string
+	// Line is 0 for the findings about a whole file
+	Line    int
+	Message string
+	// Repository is the entry with the problem, nil for the findings about
+	// a whole file
+	Repository *Repository
+	// Fix is the suggested fix
+	Fix *Fix
+}
+
+// String returns the finding in the "file:line: severity: message" form
+func (f *Finding) String() string {
+	return fmt.Sprintf("%s:%d: %s: %s", f.File, f.Line, f.Severity, f.Message)
+}
+
+// Fix is a suggested fix for a Finding, made of repository removals and
+// edits. A manual fix has only a Description.
+type Fix struct {
+	Description string
+	Remove      RepositoryList
+	// Edit are the repositories to replace, with the changed fields
+	Edit []*RepositoryChange
+}
+
+// IsManual returns true if the fix can't be applied automatically
+func (f *Fix) IsManual() bool {
+	return len(f.Remove) == 0 && len(f.Edit) == 0
+}
+
+// Apply adds the changes of the fix to the transaction
+func (f *Fix) Apply(tx *Transaction) {
+	for _, repo := range f.Remove {
+		tx.RemoveRepository(repo)
+	}
+	for _, edit := range f.Edit {
+		tx.EditRepository(edit.Old, edit.New)
+	}
+}
+
+// ApplyFixes applies together, in a single transaction, the fixes of the
+// findings returned by Lint for the specified APT config folder (usually
+// /etc/apt). Manual fixes, and the fixes of entries already changed by a
+// previous fix, are skipped.
+func ApplyFixes(findings []*Finding, configFolderPath string, opts ...Option) error {
+	tx := NewTransaction(configFolderPath, opts...)
+	changed := RepositoryList{}
+	isChanged := func(repo *Repository) bool {
+		return slices.ContainsFunc(changed, func(r *Repository) bool {
+			return r.File == repo.File && r.Line == repo.Line && r.Stanza == repo.Stanza && r.Equals(repo)
+		})
+	}
+	for _, f := range findings {
+		if f.Fix == nil || f.Fix.IsManual() {
+			continue
+		}
+		repos := slices.Clone(f.Fix.Remove)
+		for _, edit := range f.Fix.Edit {
+			repos = append(repos, edit.Old)
+		}
+		if slices.ContainsFunc(repos, isChanged) {
+			continue
+		}
+		changed = append(changed, repos...)
+		f.Fix.Apply(tx)
+	}
+	return tx.Commit()
+}
+
+// releaseCodenames maps the codenames of the Debian and Ubuntu releases
+// to their distribution
+var releaseCodenames = map[string]string{
+	"jessie": "debian", "stretch": "debian", "buster": "debian", "bullseye": "debian",
+	"bookworm": "debian", "trixie": "debian", "forky": "debian", "duke": "debian",
+	"xenial": "ubuntu", "bionic": "ubuntu", "focal": "ubuntu", "jammy": "ubuntu",
+	"kinetic": "ubuntu", "lunar": "ubuntu", "mantic": "ubuntu", "noble": "ubuntu",
+	"oracular": "ubuntu", "plucky": "ubuntu", "questing": "ubuntu",
+}
+
+// suiteCodename splits a suite like "bookworm-security" in the release
+// codename and the suffix
+func suiteCodename(suite string) (string, string) {
+	if i := strings.IndexAny(suite, "-/"); i != -1 {
+		return suite[:i], suite[i:]
+	}
+	return suite, ""
+}
+
+// Lint checks the APT configuration in the specified folder (usually
+// /etc/apt) for common problems: malformed or duplicated entries,
+// repositories for a release different from the installed one (read from
+// the os-release file), unsigned plain http repositories, the
+// "trusted=yes" option, passwords in URIs, entries disabled by a release
+// upgrade and source files without enabled entries. The findings are
+// sorted by file and line.
+func Lint(configFolderPath string, opts ...Option) ([]*Finding, error) {
+	c := newConfig(opts)
+	repos, diagnostics, err := parseAPTConfigFolder(configFolderPath, ParseLenient, c)
+	if err != nil {
+		return nil, err
+	}
+	res := []*Finding{}
+	report := func(check LintCheck, severity Severity, repo *Repository, fix *Fix, format string, args ...any) {
+		res = append(res, &Finding{
+			Check:      check,
+			Severity:   severity,
+			File:       repo.File,
+			Line:       repo.Line,
+			Message:    fmt.Sprintf(format, args...),
+			Repository: repo,
+			Fix:        fix,
+		})
+	}
+
+	for _, d := range diagnostics {
+		res = append(res, &Finding{
+			Check:    LintSyntax,
+			Severity: d.Severity,
+			File:     d.File,
+			Line:     d.Line,
+			Message:  d.Reason,
+			Fix:      &Fix{Description: "Correct or remove the entry"},
+		})
+	}
+
+	codename := ""
+	if osRelease, err := readOSRelease(configFolderPath, c); err == nil {
+		codename = osRelease["VERSION_CODENAME"]
+	}
+
+	for i, repo := range repos {
+		edit := func(description string, change func(repo *Repository)) *Fix {
+			newRepo := *repo
+			change(&newRepo)
+			return &Fix{Description: description, Edit: []*RepositoryChange{{Old: repo, New: &newRepo, Fields: repo.changedFields(&newRepo)}}}
+		}
+		remove := &Fix{Description: "Remove the entry", Remove: RepositoryList{repo}}
+
+		if repo.Enabled {
+			for _, prev := range repos[:i] {
+				if !prev.Enabled || !prev.Overlaps(repo) {
+					continue
+				}
+				fix := &Fix{Description: "Merge the entries"}
+				if prev.Equals(repo) || (repo.Options.IsEmpty() && isSubset(repo.components(), prev.components())) {
+					fix = remove
+				}
+				report(LintDuplicate, SeverityWarning, repo, fix, "the repository is already configured in %s:%d", prev.File, prev.Line)
+				break
+			}
+		}
+
+		if suite, suffix := suiteCodename(repo.Distribution); codename != "" && !repo.IsFlat() && suite != codename {
+			if family, ok := releaseCodenames[suite]; ok {
+				fix := &Fix{Description: "Check if the repository supports the release " + codename}
+				if releaseCodenames[codename] == family {
+					fix = edit("Switch to the release "+codename, func(r *Repository) { r.Distribution = codename + suffix })
+				}
+				report(LintSuiteMismatch, SeverityWarning, repo, fix, "the suite %s doesn't match the installed release %s", repo.Distribution, codename)
+			}
+		}
+
+		u, err := url.Parse(repo.URI)
+		if err == nil && (u.Scheme == "http" || u.Scheme == "ftp") && len(repo.Options.SignedBy) == 0 {
+			report(LintInsecureURI, SeverityWarning, repo, &Fix{Description: "Use https or set the signed-by option"},
+				"the repository is downloaded with %s and has no signed-by keyring", u.Scheme)
+		}
+		if err == nil && u.User != nil {
+			if _, hasPassword := u.User.Password(); hasPassword {
+				// The transaction moves the credentials of the new URI
+				fix := edit("Move the credentials to auth.conf.d/managed.conf", func(*Repository) {})
+				uri, _ := splitCredentials(repo.URI)
+				fix.Edit[0].Fields = []*FieldChange{{Field: "URI", Old: redactURI(repo.URI), New: uri}}
+				report(LintCredentials, SeverityWarning, repo, fix, "the URI contains a password")
+			}
+		}
+
+		if repo.Options.Trusted != nil && *repo.Options.Trusted {
+			// Without a keyring apt would refuse the unsigned repository
+			fix := &Fix{Description: "Set the signed-by option and remove the trusted option"}
+			if len(repo.Options.SignedBy) > 0 {
+				fix = edit("Remove the trusted option", func(r *Repository) { r.Options.Trusted = nil })
+			}
+			report(LintTrusted, SeverityWarning, repo, fix, "the repository is trusted even if it is not signed")
+		}
+
+		if !repo.Enabled && strings.Contains(repo.Comment, "disabled on upgrade to") {
+			report(LintUpgradeLeftover, SeverityInfo, repo, remove, "the entry was disabled by a release upgrade")
+		}
+	}
+
+	// Source files without enabled entries
+	sourcesFolder := filepath.Join(configFolderPath, "sources.list.d")
+	list, err := fs.ReadDir(c.fs, sourcesFolder)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s folder: %s", sourcesFolder, err)
+	}
+	for _, l := range list {
+		if !strings.HasSuffix(l.Name(), ".list") && !strings.HasSuffix(l.Name(), ".sources") {
+			continue
+		}
+		path := filepath.Join(sourcesFolder, l.Name())
+		entries := repos.Filter(func(r *Repository) bool { return r.File == path })
+		if len(entries.Enabled()) > 0 || slices.ContainsFunc(diagnostics, func(d *Diagnostic) bool { return d.File == path }) {
+			continue
+		}
+		fix := &Fix{Description: "Delete the file"}
+		if len(entries) > 0 {
+			fix = &Fix{Description: "Remove the disabled entries and the file", Remove: entries}
+		}
+		res = append(res, &Finding{
+			Check:    LintEmptyFile,
+			Severity: SeverityInfo,
+			File:     path,
+			Message:  "the file has no enabled entries",
+			Fix:      fix,
+		})
+	}
+
+	slices.SortStableFunc(res, func(a, b *Finding) int {
+		return cmp.Or(cmp.Compare(a.File, b.File), cmp.Compare(a.Line, b.Line))
+	})
+	return res, nil
+}
+
+// isSubset returns true if all the elements of a are in b
+func isSubset(a, b []string) bool {
+	return !slices.ContainsFunc(a, func(s string) bool { return !slices.Contains(b, s) })
+}
@@ -0,0 +1,153 @@
+//
+//  This file is part of go-apt-client library
+//
+//  Copyright (C) 2017  Arduino AG (http://www.arduino.cc/)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+//
+
+package apt
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// lockPollInterval is the time between two attempts to get a lock
+const lockPollInterval = 100 * time.Millisecond
+
+// LockedError is the error returned when the lock of the APT config
+// folder can't be acquired because it's held by another process (or by
+// another goroutine of the same process).
+type LockedError struct {
+	// Path is the lock file
+	Path string
+	// PID is the process that holds the lock, 0 if not known
+	PID int
+}
+
+func (e *LockedError) Error() string {
+	if e.PID > 0 {
+		return fmt.Sprintf("could not get lock %s, it is held by process %d", e.Path, e.PID)
+	}
+	return fmt.Sprintf("could not get lock %s", e.Path)
+}
+
+// WithLockFile sets the lock file used to serialize the changes to the
+// APT config folder. By default the lock of the apt lists folder is used,
+// "/var/lib/apt/lists/lock" for "/etc/apt" (located relative to the
+// config folder), that is held by apt while it reads the sources in
+// "apt update": so the changes wait for apt, like the ones made by apt
+// tools, and nothing is created in the config folder. If the lists folder
+// doesn't exist the changes are serialized only within the current
+// process. The file is always on the real disk, even if WithFS is used;
+// without this option the changes to a custom file system are serialized
+// only within the current process.
+func WithLockFile(path string) Option {
+	return func(c *config) {
+		c.lockFile = path
+	}
+}
+
+// WithLockTimeout sets how long to wait for the lock of the APT config
+// folder if it's held by someone else. With the default of 0 a
+// *LockedError is returned immediately, like apt does, a negative
+// timeout waits forever.
+func WithLockTimeout(timeout time.Duration) Option {
+	return func(c *config) {
+		c.lockTimeout = timeout
+	}
+}
+
+// processLocks are the locks held by the current process, indexed by
+// lock file. The advisory file locks are per-process, so they can't be
+// used to serialize the goroutines.
+var processLocks = struct {
+	sync.Mutex
+	m map[string]chan struct{}
+}{m: map[string]chan struct{}{}}
+
+func processLock(key string) chan struct{} {
+	processLocks.Lock()
+	defer processLocks.Unlock()
+	res, ok := processLocks.m[key]
+	if !ok {
+		res = make(chan struct{}, 1)
+		processLocks.m[key] = res
+	}
+	return res
+}
+
+// lock acquires the lock of the APT config folder, waiting up to the
+// configured timeout, and returns the function to release it
+func (c *config) lock(configFolderPath string) (func(), error) {
+	path := c.lockFile
+	_, onDisk := c.fs.(osFS)
+	if path != "" {
+		onDisk = true
+	} else {
+		path = filepath.Join(configFolderPath, "..", "..", "var", "lib", "apt", "lists", "lock")
+		if _, err := os.Stat(filepath.Dir(path)); onDisk && err != nil {
+			onDisk = false
+		}
+	}
+	key := "fs:" + path
+	if onDisk {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, fmt.Errorf("getting lock file path: %s", err)
+		}
+		key = abs
+	}
+
+	wait := lockWaiter(c.lockTimeout)
+	ch := processLock(key)
+	for acquired := false; !acquired; {
+		select {
+		case ch <- struct{}{}:
+			acquired = true
+		default:
+			if !wait() {
+				return nil, &LockedError{Path: path}
+			}
+		}
+	}
+	if !onDisk {
+		return func() { <-ch }, nil
+	}
+	unlock, err := lockFile(path, wait)
+	if err != nil {
+		<-ch
+		return nil, err
+	}
+	return func() {
+		unlock()
+		<-ch
+	}, nil
+}
+
+// lockWaiter returns a function that waits before the next attempt to
+// get a lock, it returns false once the timeout is expired
+func lockWaiter(timeout time.Duration) func() bool {
+	deadline := time.Now().Add(timeout)
+	return func() bool {
+		if timeout == 0 || (timeout > 0 && !time.Now().Before(deadline)) {
+			return false
+		}
+		time.Sleep(lockPollInterval)
+		return true
+	}
+}
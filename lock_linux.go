@@ -0,0 +1,62 @@
+//
+//  This file is part of go-apt-client library
+//
+//  Copyright (C) 2017  Arduino AG (http://www.arduino.cc/)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+//
+
+//go:build linux
+
+package apt
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+)
+
+// lockFile takes a write lock on the whole file with fcntl(F_SETLK), the
+// same kind of advisory lock used by apt and dpkg (see GetLock in apt).
+func lockFile(path string, wait func() bool) (func(), error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|syscall.O_NOFOLLOW, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("opening lock file %s: %s", path, err)
+	}
+	lock := syscall.Flock_t{Type: syscall.F_WRLCK, Whence: io.SeekStart}
+	for {
+		err := syscall.FcntlFlock(f.Fd(), syscall.F_SETLK, &lock)
+		if err == nil {
+			break
+		}
+		if err != syscall.EAGAIN && err != syscall.EACCES {
+			f.Close()
+			return nil, fmt.Errorf("locking %s: %s", path, err)
+		}
+		if !wait() {
+			res := &LockedError{Path: path}
+			holder := syscall.Flock_t{Type: syscall.F_WRLCK, Whence: io.SeekStart}
+			if syscall.FcntlFlock(f.Fd(), syscall.F_GETLK, &holder) == nil && holder.Type != syscall.F_UNLCK {
+				res.PID = int(holder.Pid)
+			}
+			f.Close()
+			return nil, res
+		}
+	}
+	return func() {
+		unlock := syscall.Flock_t{Type: syscall.F_UNLCK, Whence: io.SeekStart}
+		_ = syscall.FcntlFlock(f.Fd(), syscall.F_SETLK, &unlock)
+		f.Close()
+	}, nil
+}
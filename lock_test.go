@@ -0,0 +1,123 @@
+//
+//  This file is part of go-apt-client library
+//
+//  Copyright (C) 2017  Arduino AG (http://www.arduino.cc/)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+//
+
+package apt
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConcurrentAddRepository(t *testing.T) {
+	folder := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(folder, "sources.list.d"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(folder, "sources.list"), nil, 0644))
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			repo := &Repository{Enabled: true, URI: fmt.Sprintf("http://example.com/repo%d", i), Distribution: "stable", Components: "main"}
+			require.NoError(t, AddRepository(repo, folder, WithLockTimeout(-1)))
+		}()
+	}
+	wg.Wait()
+
+	repos, err := ParseAPTConfigFolder(folder)
+	require.NoError(t, err)
+	require.Len(t, repos, 20)
+}
+
+func TestLockTimeout(t *testing.T) {
+	fsys := NewMemFS(map[string]string{"etc/apt/sources.list": ""})
+	require.NoError(t, fsys.MkdirAll("etc/apt/sources.list.d", 0755))
+	repo := &Repository{Enabled: true, URI: "http://example.com/debian", Distribution: "stable", Components: "main"}
+
+	unlock, err := newConfig([]Option{WithFS(fsys)}).lock("etc/apt")
+	require.NoError(t, err)
+
+	err = AddRepository(repo, "etc/apt", WithFS(fsys))
+	require.EqualError(t, err, "could not get lock var/lib/apt/lists/lock")
+	require.IsType(t, &LockedError{}, err)
+
+	start := time.Now()
+	err = AddRepository(repo, "etc/apt", WithFS(fsys), WithLockTimeout(300*time.Millisecond))
+	require.IsType(t, &LockedError{}, err)
+	require.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond)
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		unlock()
+	}()
+	require.NoError(t, AddRepository(repo, "etc/apt", WithFS(fsys), WithLockTimeout(5*time.Second)))
+}
+
+// TestLockHelperProcess is not a real test, it holds the lock of the
+// folder in GO_APT_CLIENT_LOCK_FOLDER on behalf of TestLockAcrossProcesses
+func TestLockHelperProcess(t *testing.T) {
+	folder := os.Getenv("GO_APT_CLIENT_LOCK_FOLDER")
+	if folder == "" {
+		t.Skip("helper process")
+	}
+	_, err := newConfig(nil).lock(folder)
+	require.NoError(t, err)
+	fmt.Println("locked")
+	time.Sleep(time.Minute)
+}
+
+func TestLockAcrossProcesses(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("advisory file locks are supported only on linux")
+	}
+	root := t.TempDir()
+	folder := filepath.Join(root, "etc", "apt")
+	require.NoError(t, os.MkdirAll(filepath.Join(folder, "sources.list.d"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(folder, "sources.list"), nil, 0644))
+	lists := filepath.Join(root, "var", "lib", "apt", "lists")
+	require.NoError(t, os.MkdirAll(lists, 0755))
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestLockHelperProcess$")
+	cmd.Env = append(os.Environ(), "GO_APT_CLIENT_LOCK_FOLDER="+folder)
+	stdout, err := cmd.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, cmd.Start())
+	defer cmd.Wait()         //nolint:errcheck
+	defer cmd.Process.Kill() //nolint:errcheck
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "locked\n", line)
+
+	repo := &Repository{Enabled: true, URI: "http://example.com/debian", Distribution: "stable", Components: "main"}
+	err = AddRepository(repo, folder)
+	// The lock of the apt lists folder is used, like apt does
+	require.Equal(t, &LockedError{Path: filepath.Join(folder, "..", "..", "var", "lib", "apt", "lists", "lock"), PID: cmd.Process.Pid}, err)
+
+	require.NoError(t, cmd.Process.Kill())
+	require.NoError(t, AddRepository(repo, folder, WithLockTimeout(5*time.Second)))
+	require.NoFileExists(t, filepath.Join(folder, "lock"))
+}
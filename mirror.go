@@ -0,0 +1,170 @@
+//
+//  This file is part of go-apt-client library
+//
+//  Copyright (C) 2017  Arduino AG (http://www.arduino.cc/)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+//
+
+package apt
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// originPrefix returns the canonical prefix of the repository URI matched
+// by origin, or false if the URI doesn't match. The origin is either a
+// host name (like "deb.debian.org") or a URI prefix (like
+// "http://deb.debian.org/debian"), the latter matches whole path
+// segments.
+func originPrefix(uri string, origin string) (string, bool) {
+	canonical := canonicalURI(uri)
+	if !strings.Contains(origin, ":") {
+		u, err := url.Parse(canonical)
+		if err != nil || !strings.EqualFold(u.Hostname(), origin) {
+			return "", false
+		}
+		u.Path, u.RawPath, u.RawQuery, u.Fragment = "", "", "", ""
+		return u.String(), true
+	}
+	prefix := canonicalURI(origin)
+	if canonical == prefix || strings.HasPrefix(canonical, prefix+"/") {
+		return prefix, true
+	}
+	return "", false
+}
+
+// ByOrigin returns the repositories whose URI matches origin: either a
+// host name (like "deb.debian.org") or a URI prefix (like
+// "http://deb.debian.org/debian")
+func (r RepositoryList) ByOrigin(origin string) RepositoryList {
+	return r.Filter(func(repo *Repository) bool {
+		_, ok := originPrefix(repo.URI, origin)
+		return ok
+	})
+}
+
+// isMirrorMethod returns true if the URI uses the apt mirror method, where
+// the URI is the location of a list of mirrors
+func isMirrorMethod(uri string) bool {
+	return uri == "mirror" || strings.HasPrefix(uri, "mirror:") || strings.HasPrefix(uri, "mirror+")
+}
+
+// MirrorSwitch is the set of changes that point some repositories to
+// another mirror
+type MirrorSwitch struct {
+	Origin  string
+	NewBase string
+	// Changed are the rewritten repositories
+	Changed []*RepositoryChange
+
+	tx *Transaction
+}
+
+// PlanMirrorSwitch computes the changes that point the repositories in
+// the specified APT config folder (usually /etc/apt) matching origin (a
+// host name or a URI prefix, see RepositoryList.ByOrigin) to the mirror
+// at newBase. The matched part of the URIs is replaced by newBase, so
+// "http://deb.debian.org/debian" with origin "deb.debian.org" and
+// newBase "http://mirror.local" becomes "http://mirror.local/debian".
+// If newBase uses the mirror method (like "mirror+file:/etc/apt/mirrors.txt")
+// the whole URI is replaced, since the mirrors list has the full base
+// URIs. The credentials in the URIs are moved to the auth files (see
+// SetAuthEntry). Nothing is written until Apply is called.
+func PlanMirrorSwitch(origin, newBase string, configFolderPath string, opts ...Option) (*MirrorSwitch, error) {
+	if origin == "" {
+		return nil, fmt.Errorf("missing origin")
+	}
+	if u, err := url.Parse(newBase); err != nil || u.Scheme == "" || strings.ContainsAny(newBase, " \t#") {
+		return nil, fmt.Errorf("invalid base URI '%s'", newBase)
+	}
+	c := newConfig(opts)
+	repos, _, err := parseAPTConfigFolder(configFolderPath, ParseLenient, c)
+	if err != nil {
+		return nil, fmt.Errorf("parsing APT config: %s", err)
+	}
+
+	res := &MirrorSwitch{Origin: origin, NewBase: newBase, Changed: []*RepositoryChange{}, tx: newTransaction(configFolderPath, c)}
+	for _, repo := range repos {
+		prefix, ok := originPrefix(repo.URI, origin)
+		if !ok {
+			continue
+		}
+		newRepo := *repo
+		uri, credentials := splitCredentials(repo.URI)
+		if isMirrorMethod(newBase) {
+			newRepo.URI = newBase
+		} else {
+			newRepo.URI = strings.TrimSuffix(newBase, "/") + strings.TrimPrefix(canonicalURI(uri), prefix)
+		}
+		fields := repo.changedFields(&newRepo)
+		if len(fields) == 0 {
+			continue
+		}
+		if credentials != nil {
+			// The credentials are moved to the auth files, for the new
+			// location or, with a mirrors list, for the original one
+			if u, err := url.Parse(newRepo.URI); err == nil && u.Host != "" {
+				credentials.Machine = authMachine(u)
+			}
+			if err := credentials.validate(); err != nil {
+				return nil, fmt.Errorf("invalid credentials of %s: %s", repo, err)
+			}
+			for _, f := range fields {
+				f.Old, f.New = redactURI(f.Old), redactURI(f.New)
+			}
+			fields = append(fields, &FieldChange{Field: "Credentials", New: credentials.String()})
+			res.tx.credentials = append(res.tx.credentials, credentials)
+		}
+		res.Changed = append(res.Changed, &RepositoryChange{Old: repo, New: &newRepo, Fields: fields})
+		res.tx.EditRepository(repo, &newRepo)
+	}
+	return res, nil
+}
+
+// Repositories returns the repositories that are switched to the mirror
+func (s *MirrorSwitch) Repositories() RepositoryList {
+	res := RepositoryList{}
+	for _, change := range s.Changed {
+		res = append(res, change.Old)
+	}
+	return res
+}
+
+// Preview returns the changes that Apply would make, without writing
+// anything.
+func (s *MirrorSwitch) Preview() ([]*FileDiff, error) {
+	return s.tx.Preview()
+}
+
+// Apply writes all the changes together: either all the repositories are
+// switched or, on error, none of them.
+func (s *MirrorSwitch) Apply() error {
+	return s.tx.Commit()
+}
+
+// SwitchMirror points the repositories in the specified APT config folder
+// (usually /etc/apt) matching origin to the mirror at newBase, see
+// PlanMirrorSwitch. The returned MirrorSwitch reports the changes made.
+func SwitchMirror(origin, newBase string, configFolderPath string, opts ...Option) (*MirrorSwitch, error) {
+	res, err := PlanMirrorSwitch(origin, newBase, configFolderPath, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := res.Apply(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
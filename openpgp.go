@@ -0,0 +1,426 @@
+//
+//  This file is part of go-apt-client library
+//
+//  Copyright (C) 2017  Arduino AG (http://www.arduino.cc/)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+//
+
+package apt
+
+import (
+	"bytes"
+	"crypto/sha1" //nolint:gosec
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// OpenPGP packet tags (see RFC 4880)
+const (
+	openpgpTagSignature    = 2
+	openpgpTagSecretKey    = 5
+	openpgpTagPublicKey    = 6
+	openpgpTagSecretSubkey = 7
+	openpgpTagUserID       = 13
+	openpgpTagPublicSubkey = 14
+)
+
+// openpgpPacket is a raw OpenPGP packet
+type openpgpPacket struct {
+	tag  byte
+	body []byte
+	raw  []byte // the whole packet, including the header
+}
+
+// openpgpKey is an OpenPGP public key with its user IDs and subkeys, as
+// found in a keyring
+type openpgpKey struct {
+	Fingerprint       string
+	SubkeyFingerprint []string
+	UserIDs           []string
+
+	// data is the binary form of the key (a "transferable public key")
+	data []byte
+}
+
+// fingerprints returns the fingerprints of the key and of all its subkeys
+func (k *openpgpKey) fingerprints() []string {
+	return append([]string{k.Fingerprint}, k.SubkeyFingerprint...)
+}
+
+// matchesKeyID returns true if the id (a fingerprint or a long key ID)
+// identifies the key or one of its subkeys
+func (k *openpgpKey) matchesKeyID(id string) bool {
+	id = strings.ToUpper(id)
+	if len(id) < 16 {
+		return false
+	}
+	for _, fp := range k.fingerprints() {
+		if strings.HasSuffix(fp, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// ASCII-armor block types
+const (
+	armorPublicKey = "PGP PUBLIC KEY BLOCK"
+	armorSignature = "PGP SIGNATURE"
+)
+
+// isArmored returns true if the data contains an ASCII-armored key
+func isArmored(data []byte) bool {
+	return bytes.Contains(data, []byte("-----BEGIN "+armorPublicKey+"-----"))
+}
+
+// dearmor decodes all the ASCII-armored blocks of the given type in the
+// data and returns the binary form
+func dearmor(data []byte, blockType string) ([]byte, error) {
+	res := []byte{}
+	text := string(data)
+	header := "-----BEGIN " + blockType + "-----"
+	for {
+		start := strings.Index(text, header)
+		if start == -1 {
+			break
+		}
+		text = text[start+len(header):]
+		end := strings.Index(text, "-----END "+blockType+"-----")
+		if end == -1 {
+			return nil, fmt.Errorf("unterminated armored block")
+		}
+		block := text[:end]
+		text = text[end:]
+
+		// Skip the armor headers, that ends with a blank line
+		lines := strings.Split(strings.ReplaceAll(block, "\r", ""), "\n")
+		body := []string{}
+		checksum := ""
+		inHeaders := true
+		for _, line := range lines[1:] {
+			line = strings.TrimSpace(line)
+			if inHeaders {
+				if line == "" {
+					inHeaders = false
+					continue
+				}
+				if strings.Contains(line, ": ") {
+					continue
+				}
+				inHeaders = false
+			}
+			if line == "" {
+				continue
+			}
+			if strings.HasPrefix(line, "=") {
+				checksum = line[1:]
+				continue
+			}
+			body = append(body, line)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(strings.Join(body, ""))
+		if err != nil {
+			return nil, fmt.Errorf("decoding armored key: %s", err)
+		}
+		if checksum != "" {
+			expected, err := base64.StdEncoding.DecodeString(checksum)
+			if err != nil || len(expected) != 3 {
+				return nil, fmt.Errorf("invalid armor checksum")
+			}
+			crc := crc24(decoded)
+			if expected[0] != byte(crc>>16) || expected[1] != byte(crc>>8) || expected[2] != byte(crc) {
+				return nil, fmt.Errorf("armor checksum mismatch")
+			}
+		}
+		res = append(res, decoded...)
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("no armored block found")
+	}
+	return res, nil
+}
+
+func crc24(data []byte) uint32 {
+	crc := uint32(0xB704CE)
+	for _, b := range data {
+		crc ^= uint32(b) << 16
+		for i := 0; i < 8; i++ {
+			crc <<= 1
+			if crc&0x1000000 != 0 {
+				crc ^= 0x1864CFB
+			}
+		}
+	}
+	return crc & 0xFFFFFF
+}
+
+// parseOpenPGPPackets splits binary OpenPGP data in packets
+func parseOpenPGPPackets(data []byte) ([]*openpgpPacket, error) {
+	res := []*openpgpPacket{}
+	for len(data) > 0 {
+		header := data[0]
+		if header&0x80 == 0 {
+			return nil, fmt.Errorf("invalid OpenPGP packet header")
+		}
+		var tag byte
+		var length, offset int
+		if header&0x40 != 0 {
+			// New format packet
+			tag = header & 0x3F
+			if len(data) < 2 {
+				return nil, fmt.Errorf("truncated OpenPGP packet")
+			}
+			switch l := int(data[1]); {
+			case l < 192:
+				length, offset = l, 2
+			case l < 224:
+				if len(data) < 3 {
+					return nil, fmt.Errorf("truncated OpenPGP packet")
+				}
+				length, offset = ((l-192)<<8)+int(data[2])+192, 3
+			case l == 255:
+				if len(data) < 6 {
+					return nil, fmt.Errorf("truncated OpenPGP packet")
+				}
+				length, offset = int(binary.BigEndian.Uint32(data[2:6])), 6
+			default:
+				return nil, fmt.Errorf("unsupported partial length OpenPGP packet")
+			}
+		} else {
+			// Old format packet
+			tag = (header >> 2) & 0x0F
+			switch header & 0x03 {
+			case 0:
+				if len(data) < 2 {
+					return nil, fmt.Errorf("truncated OpenPGP packet")
+				}
+				length, offset = int(data[1]), 2
+			case 1:
+				if len(data) < 3 {
+					return nil, fmt.Errorf("truncated OpenPGP packet")
+				}
+				length, offset = int(binary.BigEndian.Uint16(data[1:3])), 3
+			case 2:
+				if len(data) < 5 {
+					return nil, fmt.Errorf("truncated OpenPGP packet")
+				}
+				length, offset = int(binary.BigEndian.Uint32(data[1:5])), 5
+			default:
+				length, offset = len(data)-1, 1
+			}
+		}
+		if length < 0 || offset+length > len(data) {
+			return nil, fmt.Errorf("truncated OpenPGP packet")
+		}
+		res = append(res, &openpgpPacket{
+			tag:  tag,
+			body: data[offset : offset+length],
+			raw:  data[:offset+length],
+		})
+		data = data[offset+length:]
+	}
+	return res, nil
+}
+
+// fingerprint computes the fingerprint of a public key or subkey packet
+func (p *openpgpPacket) fingerprint() (string, error) {
+	if len(p.body) == 0 {
+		return "", fmt.Errorf("empty key packet")
+	}
+	switch p.body[0] {
+	case 4:
+		h := sha1.New() //nolint:gosec
+		h.Write([]byte{0x99, byte(len(p.body) >> 8), byte(len(p.body))})
+		h.Write(p.body)
+		return strings.ToUpper(hex.EncodeToString(h.Sum(nil))), nil
+	case 5, 6:
+		h := sha256.New()
+		prefix := byte(0x9A)
+		if p.body[0] == 6 {
+			prefix = 0x9B
+		}
+		h.Write([]byte{prefix})
+		_ = binary.Write(h, binary.BigEndian, uint32(len(p.body)))
+		h.Write(p.body)
+		return strings.ToUpper(hex.EncodeToString(h.Sum(nil))), nil
+	}
+	return "", fmt.Errorf("unsupported key version %d", p.body[0])
+}
+
+// parseOpenPGPKeys parses a keyring, either binary or ASCII-armored, and
+// returns all the public keys contained in it. The keys with an
+// unsupported version (like the old v3 keys) are skipped, together with
+// their subkeys, user IDs and signatures: the result is empty if the
+// keyring contains only such keys.
+func parseOpenPGPKeys(data []byte) ([]*openpgpKey, error) {
+	if isArmored(data) {
+		d, err := dearmor(data, armorPublicKey)
+		if err != nil {
+			return nil, err
+		}
+		data = d
+	}
+	packets, err := parseOpenPGPPackets(data)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []*openpgpKey{}
+	var current *openpgpKey
+	skipping := false
+	for _, p := range packets {
+		switch p.tag {
+		case openpgpTagSecretKey, openpgpTagSecretSubkey:
+			return nil, fmt.Errorf("keyring contains a secret key")
+		case openpgpTagPublicKey:
+			if len(p.body) > 0 && !slices.Contains([]byte{4, 5, 6}, p.body[0]) {
+				current, skipping = nil, true
+				continue
+			}
+			fp, err := p.fingerprint()
+			if err != nil {
+				return nil, err
+			}
+			current, skipping = &openpgpKey{Fingerprint: fp}, false
+			res = append(res, current)
+		case openpgpTagPublicSubkey:
+			if skipping {
+				continue
+			}
+			if current == nil {
+				return nil, fmt.Errorf("subkey without primary key")
+			}
+			if fp, err := p.fingerprint(); err == nil {
+				current.SubkeyFingerprint = append(current.SubkeyFingerprint, fp)
+			}
+		case openpgpTagUserID:
+			if current != nil {
+				current.UserIDs = append(current.UserIDs, string(p.body))
+			}
+		}
+		if skipping {
+			continue
+		}
+		if current == nil {
+			return nil, fmt.Errorf("OpenPGP data doesn't start with a public key")
+		}
+		current.data = append(current.data, p.raw...)
+	}
+	if len(res) == 0 && !skipping {
+		return nil, fmt.Errorf("no public key found")
+	}
+	return res, nil
+}
+
+// signatureIssuers returns the fingerprints (or the long key IDs, if the
+// fingerprint is not available) of the keys that made the signatures
+// contained in data. The data may be a binary or ASCII-armored detached
+// signature or a clear-signed message (like an InRelease file).
+func signatureIssuers(data []byte) ([]string, error) {
+	if bytes.Contains(data, []byte("-----BEGIN "+armorSignature+"-----")) {
+		d, err := dearmor(data, armorSignature)
+		if err != nil {
+			return nil, err
+		}
+		data = d
+	}
+	packets, err := parseOpenPGPPackets(data)
+	if err != nil {
+		return nil, err
+	}
+	res := []string{}
+	for _, p := range packets {
+		if p.tag != openpgpTagSignature || len(p.body) == 0 {
+			continue
+		}
+		switch p.body[0] {
+		case 3:
+			if len(p.body) >= 15 {
+				res = append(res, strings.ToUpper(hex.EncodeToString(p.body[7:15])))
+			}
+		case 4, 5, 6:
+			if issuer := signatureSubpacketsIssuer(p.body); issuer != "" {
+				res = append(res, issuer)
+			}
+		}
+	}
+	return res, nil
+}
+
+// signatureSubpacketsIssuer extracts the issuer from the subpackets of
+// a v4 (or later) signature packet
+func signatureSubpacketsIssuer(body []byte) string {
+	if len(body) < 6 {
+		return ""
+	}
+	// The subpackets areas length is 2 octets long in v4 signatures
+	// and 4 octets long in v6 signatures
+	lenSize := 2
+	if body[0] == 6 {
+		lenSize = 4
+	}
+	data := body[4:]
+	keyID := ""
+	for area := 0; area < 2; area++ {
+		if len(data) < lenSize {
+			return keyID
+		}
+		var length int
+		if lenSize == 2 {
+			length = int(binary.BigEndian.Uint16(data))
+		} else {
+			length = int(binary.BigEndian.Uint32(data))
+		}
+		data = data[lenSize:]
+		if length > len(data) {
+			return keyID
+		}
+		subpackets := data[:length]
+		data = data[length:]
+		for len(subpackets) > 0 {
+			var size, offset int
+			switch l := int(subpackets[0]); {
+			case l < 192:
+				size, offset = l, 1
+			case l < 255 && len(subpackets) >= 2:
+				size, offset = ((l-192)<<8)+int(subpackets[1])+192, 2
+			case l == 255 && len(subpackets) >= 5:
+				size, offset = int(binary.BigEndian.Uint32(subpackets[1:5])), 5
+			default:
+				return keyID
+			}
+			if size == 0 || offset+size > len(subpackets) {
+				return keyID
+			}
+			sub := subpackets[offset : offset+size]
+			subpackets = subpackets[offset+size:]
+			switch sub[0] & 0x7F {
+			case 33: // Issuer fingerprint
+				if len(sub) > 2 {
+					return strings.ToUpper(hex.EncodeToString(sub[2:]))
+				}
+			case 16: // Issuer key ID
+				if len(sub) == 9 {
+					keyID = strings.ToUpper(hex.EncodeToString(sub[1:]))
+				}
+			}
+		}
+	}
+	return keyID
+}
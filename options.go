@@ -0,0 +1,386 @@
+//
+//  This file is part of go-apt-client library
+//
+//  Copyright (C) 2017  Arduino AG (http://www.arduino.cc/)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+//
+
+package apt
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// RepositoryOptions are the options of a repository. In the one-line
+// format they are written between brackets after the type, like
+// "[arch=amd64 signed-by=/usr/share/keyrings/x.gpg]", in the deb822 format
+// they are fields of the stanza, like "Architectures: amd64".
+type RepositoryOptions struct {
+	// Architectures is the "arch" option (Architectures in deb822)
+	Architectures OptionList
+	// Languages is the "lang" option (Languages in deb822)
+	Languages OptionList
+	// Targets is the "target" option (Targets in deb822)
+	Targets OptionList
+	// SignedBy is the list of keyring files or key fingerprints of the
+	// "signed-by" option (Signed-By in deb822). In deb822 files the
+	// option may contain an embedded ASCII-armored key, that is kept as
+	// a single multi-line element.
+	SignedBy []string
+	// Trusted is the "trusted" option, nil if not set
+	Trusted *bool
+	// CheckValidUntil is the "check-valid-until" option, nil if not set
+	CheckValidUntil *bool
+	// Extra contains all the other options
+	Extra []RawOption
+
+	// order contains the options, in the "key+op" form, in the same
+	// order they were parsed and it's used to keep the original layout
+	order []string
+}
+
+// OptionList is the value of a multi-valued option. The values may be
+// replaced (key=a,b), extended (key+=c) or reduced (key-=d).
+type OptionList struct {
+	Set    []string
+	Add    []string
+	Remove []string
+}
+
+// IsEmpty returns true if no value is specified
+func (l OptionList) IsEmpty() bool {
+	return len(l.Set) == 0 && len(l.Add) == 0 && len(l.Remove) == 0
+}
+
+// Equals returns true if the two OptionList contain the same values,
+// regardless of their order.
+func (l OptionList) Equals(other OptionList) bool {
+	return sameSet(l.Set, other.Set) && sameSet(l.Add, other.Add) && sameSet(l.Remove, other.Remove)
+}
+
+// RawOption is a repository option not directly supported by RepositoryOptions
+type RawOption struct {
+	Key string
+	// Operator is one of "=", "+=" or "-="
+	Operator string
+	Value    string
+}
+
+// ParseRepositoryOptions parses the options of a repository in the one-line
+// format, that is the text between brackets, like "arch=amd64 trusted=yes".
+func ParseRepositoryOptions(text string) (RepositoryOptions, error) {
+	res := RepositoryOptions{}
+	for _, opt := range strings.Fields(text) {
+		key, value, ok := strings.Cut(opt, "=")
+		if !ok || key == "" {
+			return res, fmt.Errorf("invalid option '%s'", opt)
+		}
+		op := "="
+		if k, ok := strings.CutSuffix(key, "+"); ok {
+			key, op = k, "+="
+		} else if k, ok := strings.CutSuffix(key, "-"); ok {
+			key, op = k, "-="
+		}
+		if err := res.set(key, op, splitOptionValues(value)); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// MustParseRepositoryOptions is like ParseRepositoryOptions but panics if
+// the options can't be parsed.
+func MustParseRepositoryOptions(text string) RepositoryOptions {
+	res, err := ParseRepositoryOptions(text)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+func splitOptionValues(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' || r == '\n' })
+}
+
+// set adds the option key with the given operator and values
+func (o *RepositoryOptions) set(key, op string, values []string) error {
+	list := func(l *OptionList) {
+		switch op {
+		case "+=":
+			l.Add = append(l.Add, values...)
+		case "-=":
+			l.Remove = append(l.Remove, values...)
+		default:
+			l.Set = append(l.Set, values...)
+		}
+	}
+	boolean := func(b **bool) error {
+		if op != "=" || len(values) != 1 {
+			return fmt.Errorf("invalid value for option '%s'", key)
+		}
+		switch strings.ToLower(values[0]) {
+		case "yes", "true", "with", "on", "enable":
+			v := true
+			*b = &v
+		case "no", "false", "without", "off", "disable":
+			v := false
+			*b = &v
+		default:
+			return fmt.Errorf("invalid value '%s' for option '%s'", values[0], key)
+		}
+		return nil
+	}
+
+	switch {
+	case key == "arch":
+		list(&o.Architectures)
+	case key == "lang":
+		list(&o.Languages)
+	case key == "target":
+		list(&o.Targets)
+	case key == "signed-by" && op == "=":
+		o.SignedBy = append(o.SignedBy, values...)
+	case key == "trusted":
+		if err := boolean(&o.Trusted); err != nil {
+			return err
+		}
+	case key == "check-valid-until":
+		if err := boolean(&o.CheckValidUntil); err != nil {
+			return err
+		}
+	default:
+		o.Extra = append(o.Extra, RawOption{Key: key, Operator: op, Value: strings.Join(values, ",")})
+	}
+	if !slices.Contains(o.order, key+op) {
+		o.order = append(o.order, key+op)
+	}
+	return nil
+}
+
+// optionEntry is an option with all its values
+type optionEntry struct {
+	key    string
+	op     string
+	values []string
+}
+
+// entries returns all the options, in the original order if the options
+// have been parsed, followed by the new ones.
+func (o RepositoryOptions) entries() []optionEntry {
+	res := []optionEntry{}
+	addList := func(key string, l OptionList) {
+		if len(l.Set) > 0 {
+			res = append(res, optionEntry{key, "=", l.Set})
+		}
+		if len(l.Add) > 0 {
+			res = append(res, optionEntry{key, "+=", l.Add})
+		}
+		if len(l.Remove) > 0 {
+			res = append(res, optionEntry{key, "-=", l.Remove})
+		}
+	}
+	addBool := func(key string, b *bool) {
+		if b == nil {
+			return
+		}
+		value := "no"
+		if *b {
+			value = "yes"
+		}
+		res = append(res, optionEntry{key, "=", []string{value}})
+	}
+	addList("arch", o.Architectures)
+	addList("lang", o.Languages)
+	addList("target", o.Targets)
+	if len(o.SignedBy) > 0 {
+		res = append(res, optionEntry{"signed-by", "=", o.SignedBy})
+	}
+	addBool("trusted", o.Trusted)
+	addBool("check-valid-until", o.CheckValidUntil)
+	for _, extra := range o.Extra {
+		res = append(res, optionEntry{extra.Key, extra.Operator, splitOptionValues(extra.Value)})
+	}
+
+	position := func(e optionEntry) int {
+		if idx := slices.Index(o.order, e.key+e.op); idx != -1 {
+			return idx
+		}
+		return len(o.order)
+	}
+	slices.SortStableFunc(res, func(a, b optionEntry) int {
+		return cmp.Compare(position(a), position(b))
+	})
+	return res
+}
+
+// IsEmpty returns true if no option is set
+func (o RepositoryOptions) IsEmpty() bool {
+	return len(o.entries()) == 0
+}
+
+// String returns the options in the one-line format, without brackets
+func (o RepositoryOptions) String() string {
+	res := []string{}
+	for _, e := range o.entries() {
+		res = append(res, e.key+e.op+strings.Join(e.values, ","))
+	}
+	return strings.Join(res, " ")
+}
+
+// validateOneLine checks that the options can be written in the one-line
+// format: embedded keys, and values with spaces, brackets or comments,
+// are supported only in the deb822 format.
+func (o RepositoryOptions) validateOneLine() error {
+	for _, e := range o.entries() {
+		for _, text := range append([]string{e.key}, e.values...) {
+			if strings.HasPrefix(text, "-----BEGIN") {
+				return fmt.Errorf("the embedded key of option '%s' can't be written in a one-line entry", e.key)
+			}
+			if text == "" || strings.ContainsAny(text, " \t\r\n[]#=,") {
+				return fmt.Errorf("the value of option '%s' can't be written in a one-line entry", e.key)
+			}
+		}
+	}
+	return nil
+}
+
+// Equals returns true if the options are equivalent to the ones
+// provided as parameter, the order of the options and of their values
+// is not relevant.
+func (o RepositoryOptions) Equals(other RepositoryOptions) bool {
+	if !o.Architectures.Equals(other.Architectures) ||
+		!o.Languages.Equals(other.Languages) ||
+		!o.Targets.Equals(other.Targets) ||
+		!sameSet(o.SignedBy, other.SignedBy) ||
+		!sameBool(o.Trusted, other.Trusted) ||
+		!sameBool(o.CheckValidUntil, other.CheckValidUntil) {
+		return false
+	}
+	extras := func(opts []RawOption) []string {
+		res := []string{}
+		for _, opt := range opts {
+			res = append(res, opt.Key+opt.Operator+strings.Join(splitOptionValues(opt.Value), ","))
+		}
+		return res
+	}
+	return sameSet(extras(o.Extra), extras(other.Extra))
+}
+
+// MarshalText implements encoding.TextMarshaler, the options are
+// encoded in the one-line format.
+func (o RepositoryOptions) MarshalText() ([]byte, error) {
+	return []byte(o.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (o *RepositoryOptions) UnmarshalText(text []byte) error {
+	res, err := ParseRepositoryOptions(string(text))
+	if err != nil {
+		return err
+	}
+	*o = res
+	return nil
+}
+
+func sameSet(a, b []string) bool {
+	a = slices.Compact(slices.Sorted(slices.Values(a)))
+	b = slices.Compact(slices.Sorted(slices.Values(b)))
+	return slices.Equal(a, b)
+}
+
+func sameBool(a, b *bool) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// deb822OptionFields maps the one-line options to the corresponding
+// deb822 fields (see sources.list(5) for details).
+var deb822OptionFields = map[string]string{
+	"arch":                        "Architectures",
+	"lang":                        "Languages",
+	"target":                      "Targets",
+	"pdiffs":                      "PDiffs",
+	"by-hash":                     "By-Hash",
+	"allow-insecure":              "Allow-Insecure",
+	"allow-weak":                  "Allow-Weak",
+	"allow-downgrade-to-insecure": "Allow-Downgrade-To-Insecure",
+	"trusted":                     "Trusted",
+	"signed-by":                   "Signed-By",
+	"check-valid-until":           "Check-Valid-Until",
+	"valid-until-min":             "Valid-Until-Min",
+	"valid-until-max":             "Valid-Until-Max",
+	"check-date":                  "Check-Date",
+	"date-max-future":             "Date-Max-Future",
+	"inrelease-path":              "InRelease-Path",
+	"snapshot":                    "Snapshot",
+}
+
+// deb822RepositoryFields are the fields that defines the repository
+// itself, all the other fields (except the "X-" ones) are options.
+var deb822RepositoryFields = []string{"Types", "URIs", "Suites", "Components", "Enabled"}
+
+// deb822FieldToOption converts a deb822 field name to the one-line option
+// key and operator, it returns false if the field is not an option.
+func deb822FieldToOption(name string) (string, string, bool) {
+	if strings.HasPrefix(strings.ToLower(name), "x-") {
+		return "", "", false
+	}
+	for _, f := range deb822RepositoryFields {
+		if strings.EqualFold(f, name) {
+			return "", "", false
+		}
+	}
+	op := "="
+	base := name
+	if b, ok := cutSuffixFold(name, "-Add"); ok {
+		base, op = b, "+="
+	} else if b, ok := cutSuffixFold(name, "-Remove"); ok {
+		base, op = b, "-="
+	}
+	for key, field := range deb822OptionFields {
+		if strings.EqualFold(field, base) {
+			return key, op, true
+		}
+	}
+	// Only the known options support the -Add/-Remove suffix
+	return strings.ToLower(name), "=", true
+}
+
+// optionToDeb822Field converts a one-line option key and operator to the
+// corresponding deb822 field name.
+func optionToDeb822Field(key, op string) string {
+	field, ok := deb822OptionFields[key]
+	if !ok {
+		field = key
+	}
+	switch op {
+	case "+=":
+		return field + "-Add"
+	case "-=":
+		return field + "-Remove"
+	}
+	return field
+}
+
+func cutSuffixFold(s, suffix string) (string, bool) {
+	if len(s) > len(suffix) && strings.EqualFold(s[len(s)-len(suffix):], suffix) {
+		return s[:len(s)-len(suffix)], true
+	}
+	return s, false
+}
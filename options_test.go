@@ -0,0 +1,181 @@
+//
+//  This file is part of go-apt-client library
+//
+//  Copyright (C) 2017  Arduino AG (http://www.arduino.cc/)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+//
+
+package apt
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRepositoryOptions(t *testing.T) {
+	opts, err := ParseRepositoryOptions("arch=amd64,i386 lang-=it signed-by=/usr/share/keyrings/x.gpg trusted=yes pdiffs=no target+=Contents-deb")
+	require.NoError(t, err)
+	require.Equal(t, []string{"amd64", "i386"}, opts.Architectures.Set)
+	require.Equal(t, []string{"it"}, opts.Languages.Remove)
+	require.Equal(t, []string{"Contents-deb"}, opts.Targets.Add)
+	require.Equal(t, []string{"/usr/share/keyrings/x.gpg"}, opts.SignedBy)
+	require.NotNil(t, opts.Trusted)
+	require.True(t, *opts.Trusted)
+	require.Nil(t, opts.CheckValidUntil)
+	require.Equal(t, []RawOption{{Key: "pdiffs", Operator: "=", Value: "no"}}, opts.Extra)
+
+	// The original order is retained
+	require.Equal(t, "arch=amd64,i386 lang-=it signed-by=/usr/share/keyrings/x.gpg trusted=yes pdiffs=no target+=Contents-deb", opts.String())
+
+	// New options are appended
+	no := false
+	opts.CheckValidUntil = &no
+	opts.Languages = OptionList{}
+	require.Equal(t, "arch=amd64,i386 signed-by=/usr/share/keyrings/x.gpg trusted=yes pdiffs=no target+=Contents-deb check-valid-until=no", opts.String())
+
+	_, err = ParseRepositoryOptions("arch")
+	require.Error(t, err)
+	_, err = ParseRepositoryOptions("trusted=maybe")
+	require.Error(t, err)
+
+	empty, err := ParseRepositoryOptions("  ")
+	require.NoError(t, err)
+	require.True(t, empty.IsEmpty())
+}
+
+func TestRepositoryOptionsEquals(t *testing.T) {
+	a := MustParseRepositoryOptions("arch=amd64 trusted=yes")
+	b := MustParseRepositoryOptions("trusted=yes arch=amd64")
+	require.True(t, a.Equals(b))
+	require.True(t, MustParseRepositoryOptions("arch=amd64,i386").Equals(MustParseRepositoryOptions("arch=i386,amd64")))
+	require.False(t, a.Equals(MustParseRepositoryOptions("arch=amd64 trusted=no")))
+	require.False(t, a.Equals(MustParseRepositoryOptions("arch=amd64")))
+
+	repo1 := &Repository{URI: "http://example.com", Distribution: "stable", Components: "main", Options: a}
+	repo2 := &Repository{URI: "http://example.com", Distribution: "stable", Components: "main", Options: b}
+	require.True(t, repo1.Equals(repo2))
+}
+
+func TestRepositoryOptionsJSON(t *testing.T) {
+	repo := &Repository{Options: MustParseRepositoryOptions("arch=amd64 signed-by=/x.gpg")}
+	data, err := json.Marshal(repo)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"Options":"arch=amd64 signed-by=/x.gpg"`)
+
+	decoded := &Repository{}
+	require.NoError(t, json.Unmarshal(data, decoded))
+	require.True(t, decoded.Equals(repo))
+}
+
+func TestDeb822RepositoryOptions(t *testing.T) {
+	data := "Types: deb\n" +
+		"URIs: http://example.com/debian\n" +
+		"Suites: stable\n" +
+		"Components: main\n" +
+		"Architectures-Add: arm64\n" +
+		"Signed-By: /etc/apt/keyrings/a.gpg\n" +
+		" /etc/apt/keyrings/b.gpg\n" +
+		"X-Repolib-Name: Example\n" +
+		"Snapshot: enable\n"
+	file := parseSourceFile("example.sources", []byte(data))
+	repos := file.repositories()
+	require.Len(t, repos, 1)
+	opts := repos[0].Options
+	require.Equal(t, []string{"arm64"}, opts.Architectures.Add)
+	require.Equal(t, []string{"/etc/apt/keyrings/a.gpg", "/etc/apt/keyrings/b.gpg"}, opts.SignedBy)
+	require.Equal(t, []RawOption{{Key: "snapshot", Operator: "=", Value: "enable"}}, opts.Extra)
+	require.Equal(t, "arch+=arm64 signed-by=/etc/apt/keyrings/a.gpg,/etc/apt/keyrings/b.gpg snapshot=enable", opts.String())
+
+	// Changing an option leaves the other fields untouched
+	newRepo := *repos[0]
+	newRepo.Options = MustParseRepositoryOptions(opts.String())
+	newRepo.Options.Architectures = OptionList{Set: []string{"amd64"}}
+	yes := true
+	newRepo.Options.Trusted = &yes
+	require.True(t, file.replace(repos[0], &newRepo))
+	require.Equal(t, "Types: deb\n"+
+		"URIs: http://example.com/debian\n"+
+		"Suites: stable\n"+
+		"Components: main\n"+
+		"Signed-By: /etc/apt/keyrings/a.gpg\n"+
+		" /etc/apt/keyrings/b.gpg\n"+
+		"X-Repolib-Name: Example\n"+
+		"Snapshot: enable\n"+
+		"Architectures: amd64\n"+
+		"Trusted: yes\n", string(file.bytes()))
+}
+
+func TestDeb822EmbeddedSignedBy(t *testing.T) {
+	data := "Types: deb\n" +
+		"URIs: http://example.com/debian\n" +
+		"Suites: stable\n" +
+		"Components: main\n" +
+		"Signed-By:\n" +
+		" -----BEGIN PGP PUBLIC KEY BLOCK-----\n" +
+		" .\n" +
+		" mQINBFit2ioBEADhWpZ8/wvZ6hUTiXOwQHXMAlaFHcPH9hAtr4F1y2+OYdbtMuth\n" +
+		" -----END PGP PUBLIC KEY BLOCK-----\n"
+	file := parseSourceFile("example.sources", []byte(data))
+	repos := file.repositories()
+	require.Len(t, repos, 1)
+	require.Equal(t, []string{"-----BEGIN PGP PUBLIC KEY BLOCK-----\n\nmQINBFit2ioBEADhWpZ8/wvZ6hUTiXOwQHXMAlaFHcPH9hAtr4F1y2+OYdbtMuth\n-----END PGP PUBLIC KEY BLOCK-----"}, repos[0].Options.SignedBy)
+
+	newRepo := *repos[0]
+	newRepo.Distribution = "testing"
+	require.True(t, file.replace(repos[0], &newRepo))
+	require.Equal(t, strings.Replace(data, "Suites: stable", "Suites: testing", 1), string(file.bytes()))
+}
+
+func TestOneLineOptions(t *testing.T) {
+	fsys := NewMemFS(map[string]string{
+		"etc/apt/sources.list": "deb https://deb.debian.org/debian bookworm main\n",
+		"etc/apt/sources.list.d/example.sources": "Types: deb\n" +
+			"URIs: http://example.com/debian\n" +
+			"Suites: stable\n" +
+			"Components: main\n" +
+			"Signed-By:\n" +
+			" -----BEGIN PGP PUBLIC KEY BLOCK-----\n" +
+			" .\n" +
+			" mQINBFit2ioBEADhWpZ8/wvZ6hUTiXOwQHXMAlaFHcPH9hAtr4F1y2+OYdbtMuth\n" +
+			" -----END PGP PUBLIC KEY BLOCK-----\n",
+	})
+	repos, err := ParseAPTConfigFolder("etc/apt", WithFS(fsys))
+	require.NoError(t, err)
+	require.Len(t, repos, 2)
+
+	// The embedded key can't be written in a ".list" file
+	repo := *repos[1]
+	repo.URI = "http://mirror.example.com/debian"
+	err = AddRepository(&repo, "etc/apt", WithFS(fsys))
+	require.EqualError(t, err, "invalid repository: the embedded key of option 'signed-by' can't be written in a one-line entry")
+	require.NoError(t, AddRepository(&repo, "etc/apt", WithFS(fsys), WithTargetFile("managed.sources")))
+	_, _, err = ParseAPTConfigFolderWithDiagnostics("etc/apt", ParseStrict, WithFS(fsys))
+	require.NoError(t, err)
+
+	for _, opts := range []RepositoryOptions{
+		{SignedBy: []string{"/etc/apt/keyrings/my key.gpg"}},
+		{Architectures: OptionList{Set: []string{"amd64]"}}},
+		{Extra: []RawOption{{Key: "x-comment", Operator: "=", Value: "#1"}}},
+	} {
+		newRepo := *repos[0]
+		newRepo.Options = opts
+		err = EditRepository(repos[0], &newRepo, "etc/apt", WithFS(fsys))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "can't be written in a one-line entry")
+	}
+	require.Equal(t, "deb https://deb.debian.org/debian bookworm main\n", readFSFile(t, fsys, "etc/apt/sources.list"))
+}
@@ -0,0 +1,247 @@
+//
+//  This file is part of go-apt-client library
+//
+//  Copyright (C) 2017  Arduino AG (http://www.arduino.cc/)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+//
+
+package apt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultLaunchpadAPI is the base URL of the Launchpad API used to fetch
+// the signing keys of the PPAs
+const DefaultLaunchpadAPI = "https://api.launchpad.net/devel"
+
+// ppaArchiveURL is the base URL of the PPAs packages
+const ppaArchiveURL = "https://ppa.launchpadcontent.net"
+
+var ppaNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9.+-]*$`)
+
+var launchpadClient = &http.Client{Timeout: 30 * time.Second}
+
+// WithLaunchpadAPI sets the base URL of the Launchpad API used to fetch
+// the signing keys of the PPAs, DefaultLaunchpadAPI if not set.
+func WithLaunchpadAPI(baseURL string) Option {
+	return func(c *config) {
+		c.launchpadAPI = baseURL
+	}
+}
+
+// PPA is a Launchpad Personal Package Archive
+type PPA struct {
+	Owner string
+	Name  string
+}
+
+// ParsePPA parses the "ppa:owner/name" shorthand used by
+// add-apt-repository. If the name is omitted ("ppa:owner") the PPA
+// named "ppa" is selected.
+func ParsePPA(shorthand string) (*PPA, error) {
+	spec, ok := strings.CutPrefix(shorthand, "ppa:")
+	if !ok {
+		return nil, fmt.Errorf("invalid PPA '%s': missing 'ppa:' prefix", shorthand)
+	}
+	owner, name, found := strings.Cut(spec, "/")
+	if !found {
+		name = "ppa"
+	}
+	if !ppaNameRegexp.MatchString(owner) || !ppaNameRegexp.MatchString(name) {
+		return nil, fmt.Errorf("invalid PPA '%s'", shorthand)
+	}
+	return &PPA{Owner: owner, Name: name}, nil
+}
+
+// String returns the PPA shorthand
+func (p *PPA) String() string {
+	return "ppa:" + p.Owner + "/" + p.Name
+}
+
+// URI returns the URI of the PPA packages
+func (p *PPA) URI() string {
+	return ppaArchiveURL + "/" + p.Owner + "/" + p.Name + "/ubuntu"
+}
+
+// keyringName returns the name of the keyring of the PPA signing key
+func (p *PPA) keyringName() string {
+	return p.Owner + "-ubuntu-" + p.Name
+}
+
+// fileName returns the name of the source file used by add-apt-repository
+// for the PPA
+func (p *PPA) fileName(codename string) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '.' || r == '+' {
+			return '_'
+		}
+		return r
+	}, p.keyringName()+"-"+codename)
+	return name + ".list"
+}
+
+// signingKey fetches the ASCII-armored signing key of the PPA from the
+// Launchpad API
+func (p *PPA) signingKey(baseURL string) ([]byte, error) {
+	if baseURL == "" {
+		baseURL = DefaultLaunchpadAPI
+	}
+	url := strings.TrimSuffix(baseURL, "/") + "/~" + p.Owner + "/+archive/ubuntu/" + p.Name + "?ws.op=getSigningKeyData"
+	resp, err := launchpadClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response from %s: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	// The key is returned as a JSON string
+	var key string
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, fmt.Errorf("decoding response from %s: %s", url, err)
+	}
+	if key == "" {
+		return nil, fmt.Errorf("the PPA has no signing key")
+	}
+	return []byte(key), nil
+}
+
+// ResolvePPA expands the "ppa:owner/name" shorthand in the Repository of
+// the PPA for the distribution codename read from the os-release file,
+// and fetches the PPA signing key from the Launchpad API. The "signed-by"
+// option of the Repository points to the keyring in the specified APT
+// config folder (usually /etc/apt) where AddRepositoryWithKey installs
+// the key.
+func ResolvePPA(shorthand string, configFolderPath string, opts ...Option) (*Repository, []byte, error) {
+	c := newConfig(opts)
+	ppa, err := ParsePPA(shorthand)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resolvePPA(ppa, configFolderPath, c)
+}
+
+func resolvePPA(ppa *PPA, configFolderPath string, c *config) (*Repository, []byte, error) {
+	osRelease, err := readOSRelease(configFolderPath, c)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading os-release: %s", err)
+	}
+	codename := osRelease["VERSION_CODENAME"]
+	if codename == "" {
+		codename = osRelease["UBUNTU_CODENAME"]
+	}
+	if codename == "" {
+		return nil, nil, fmt.Errorf("the distribution codename is unknown")
+	}
+	key, err := ppa.signingKey(c.launchpadAPI)
+	if err != nil {
+		return nil, nil, fmt.Errorf("fetching signing key of %s: %s", ppa, err)
+	}
+	folder, err := keyringsFolder(configFolderPath, c)
+	if err != nil {
+		return nil, nil, fmt.Errorf("getting keyrings folder: %s", err)
+	}
+	repo := &Repository{
+		Enabled:      true,
+		URI:          ppa.URI(),
+		Distribution: codename,
+		Components:   "main",
+	}
+	repo.Options.SignedBy = []string{filepath.Join(folder, ppa.keyringName()+".gpg")}
+	return repo, key, nil
+}
+
+// AddPPA resolves the "ppa:owner/name" shorthand, like ResolvePPA, and
+// adds the PPA together with its signing key, like AddRepositoryWithKey.
+// Unless another file is selected with WithTargetFile, the PPA is saved
+// in the file that add-apt-repository would use (for example
+// "owner-ubuntu-name-noble.list").
+func AddPPA(shorthand string, configFolderPath string, opts ...Option) (*Repository, error) {
+	c := newConfig(opts)
+	ppa, err := ParsePPA(shorthand)
+	if err != nil {
+		return nil, err
+	}
+	repo, key, err := resolvePPA(ppa, configFolderPath, c)
+	if err != nil {
+		return nil, err
+	}
+	opts = append([]Option{WithTargetFile(ppa.fileName(repo.Distribution))}, opts...)
+	return AddRepositoryWithKey(repo, ppa.keyringName(), key, configFolderPath, opts...)
+}
+
+// readOSRelease reads the os-release file of the system the APT config
+// folder belongs to: "os-release" in the parent folder (usually
+// /etc/os-release) or, if missing, "usr/lib/os-release" in the same root,
+// so that the right file is used also for the image of another system
+func readOSRelease(configFolderPath string, c *config) (map[string]string, error) {
+	data, err := fs.ReadFile(c.fs, filepath.Join(configFolderPath, "..", "os-release"))
+	if os.IsNotExist(err) {
+		data, err = fs.ReadFile(c.fs, filepath.Join(configFolderPath, "..", "..", "usr", "lib", "os-release"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseOSRelease(data), nil
+}
+
+// parseOSRelease parses the content of an os-release file: a list of
+// KEY=value assignments where the value may be enclosed in single or
+// double quotes. Comments and invalid lines are ignored.
+func parseOSRelease(data []byte) map[string]string {
+	res := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || key == "" {
+			continue
+		}
+		if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+			value = value[1 : len(value)-1]
+		} else if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			// Inside double quotes '\' escapes the characters '"', '\',
+			// '$' and '`'
+			var unquoted strings.Builder
+			for i := 1; i < len(value)-1; i++ {
+				if value[i] == '\\' && i+1 < len(value)-1 && strings.IndexByte("\"\\$`", value[i+1]) != -1 {
+					i++
+				}
+				unquoted.WriteByte(value[i])
+			}
+			value = unquoted.String()
+		}
+		res[key] = value
+	}
+	return res
+}
@@ -0,0 +1,128 @@
+//
+//  This file is part of go-apt-client library
+//
+//  Copyright (C) 2017  Arduino AG (http://www.arduino.cc/)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+//
+
+package apt
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePPA(t *testing.T) {
+	ppa, err := ParsePPA("ppa:deadsnakes/nightly")
+	require.NoError(t, err)
+	require.Equal(t, &PPA{Owner: "deadsnakes", Name: "nightly"}, ppa)
+	require.Equal(t, "https://ppa.launchpadcontent.net/deadsnakes/nightly/ubuntu", ppa.URI())
+	require.Equal(t, "deadsnakes-ubuntu-nightly_1-noble.list", (&PPA{Owner: "deadsnakes", Name: "nightly.1"}).fileName("noble"))
+
+	ppa, err = ParsePPA("ppa:git-core")
+	require.NoError(t, err)
+	require.Equal(t, "ppa:git-core/ppa", ppa.String())
+
+	for _, s := range []string{"deadsnakes/nightly", "ppa:", "ppa:/name", "ppa:owner/", "ppa:Owner/name", "ppa:owner/na/me", "ppa:../name"} {
+		_, err := ParsePPA(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestParseOSRelease(t *testing.T) {
+	data := "# Comment\n" +
+		"NAME=\"Ubuntu\"\n" +
+		"VERSION_ID='24.04'\n" +
+		"VERSION_CODENAME=noble\n" +
+		"PRETTY_NAME=\"Ubuntu \\\"Noble\\\" \\$NAME\"\n" +
+		"invalid line\n"
+	require.Equal(t, map[string]string{
+		"NAME":             "Ubuntu",
+		"VERSION_ID":       "24.04",
+		"VERSION_CODENAME": "noble",
+		"PRETTY_NAME":      "Ubuntu \"Noble\" $NAME",
+	}, parseOSRelease([]byte(data)))
+}
+
+func newLaunchpadStub(t *testing.T) *httptest.Server {
+	armored, err := os.ReadFile("testdata/keys/example.asc")
+	require.NoError(t, err)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/~owner/+archive/ubuntu/name" || r.URL.Query().Get("ws.op") != "getSigningKeyData" {
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(string(armored))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestResolvePPA(t *testing.T) {
+	server := newLaunchpadStub(t)
+	fsys := NewMemFS(map[string]string{
+		"etc/apt/sources.list": "",
+		"etc/os-release":       "NAME=\"Ubuntu\"\nVERSION_CODENAME=noble\n",
+	})
+	require.NoError(t, fsys.MkdirAll("etc/apt/sources.list.d", 0755))
+	armored, err := os.ReadFile("testdata/keys/example.asc")
+	require.NoError(t, err)
+	binary, err := os.ReadFile("testdata/keys/example.gpg")
+	require.NoError(t, err)
+
+	repo, key, err := ResolvePPA("ppa:owner/name", "etc/apt", WithFS(fsys), WithLaunchpadAPI(server.URL))
+	require.NoError(t, err)
+	require.Equal(t, armored, key)
+	expected := &Repository{
+		Enabled:      true,
+		URI:          "https://ppa.launchpadcontent.net/owner/name/ubuntu",
+		Distribution: "noble",
+		Components:   "main",
+		Options:      RepositoryOptions{SignedBy: []string{"/etc/apt/keyrings/owner-ubuntu-name.gpg"}},
+	}
+	require.Equal(t, expected, repo)
+
+	added, err := AddPPA("ppa:owner/name", "etc/apt", WithFS(fsys), WithLaunchpadAPI(server.URL+"/"))
+	require.NoError(t, err)
+	require.Equal(t, expected, added)
+	require.Equal(t, "deb [signed-by=/etc/apt/keyrings/owner-ubuntu-name.gpg] https://ppa.launchpadcontent.net/owner/name/ubuntu noble main\n",
+		readFSFile(t, fsys, "etc/apt/sources.list.d/owner-ubuntu-name-noble.list"))
+	require.Equal(t, string(binary), readFSFile(t, fsys, "etc/apt/keyrings/owner-ubuntu-name.gpg"))
+
+	_, _, err = ResolvePPA("ppa:owner/missing", "etc/apt", WithFS(fsys), WithLaunchpadAPI(server.URL))
+	require.ErrorContains(t, err, "fetching signing key of ppa:owner/missing: unexpected response")
+
+	// The codename is required
+	fsys = NewMemFS(map[string]string{"usr/lib/os-release": "NAME=\"Debian\"\n"})
+	_, _, err = ResolvePPA("ppa:owner/name", "etc/apt", WithFS(fsys), WithLaunchpadAPI(server.URL))
+	require.EqualError(t, err, "the distribution codename is unknown")
+	fsys = NewMemFS(map[string]string{"usr/lib/os-release": "UBUNTU_CODENAME=jammy\n"})
+	repo, _, err = ResolvePPA("ppa:owner/name", "etc/apt", WithFS(fsys), WithLaunchpadAPI(server.URL))
+	require.NoError(t, err)
+	require.Equal(t, "jammy", repo.Distribution)
+
+	// The os-release file of a mounted image is next to its config folder
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "etc", "apt"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "etc", "os-release"), []byte("VERSION_CODENAME=plucky\n"), 0644))
+	repo, _, err = ResolvePPA("ppa:owner/name", filepath.Join(root, "etc", "apt"), WithLaunchpadAPI(server.URL))
+	require.NoError(t, err)
+	require.Equal(t, "plucky", repo.Distribution)
+}
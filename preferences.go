@@ -0,0 +1,334 @@
+//
+//  This file is part of go-apt-client library
+//
+//  Copyright (C) 2017  Arduino AG (http://www.arduino.cc/)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+//
+
+package apt
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Preference is a stanza of the APT preferences, that sets the priority
+// of the versions of some packages (see apt_preferences(5))
+type Preference struct {
+	// Packages are the package patterns: names, globs (like "gnome*"),
+	// regular expressions enclosed in slashes (like "/^gnome/") or "*"
+	// for all the packages
+	Packages []string
+	// Pin selects the versions, like "release a=stable",
+	// "origin example.com" or "version 1.2*"
+	Pin      string
+	Priority int
+	// Explanation is a free text comment, one line for each Explanation
+	// field
+	Explanation string
+
+	// File is the path of the preferences file that defines the stanza
+	File string `json:",omitempty"`
+	// Stanza is the index (starting from 1) of the stanza in File
+	Stanza int `json:",omitempty"`
+}
+
+// Equals returns true if the preference selects the same versions with
+// the same priority, regardless of the order of the package patterns,
+// the Explanation and the position
+func (p *Preference) Equals(other *Preference) bool {
+	return sameSet(p.Packages, other.Packages) &&
+		strings.Join(strings.Fields(p.Pin), " ") == strings.Join(strings.Fields(other.Pin), " ") &&
+		p.Priority == other.Priority
+}
+
+// isRegexPattern returns true if the package pattern is a regular
+// expression, like "/^gnome/"
+func isRegexPattern(pattern string) bool {
+	return len(pattern) >= 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/")
+}
+
+// Matches returns true if the package name matches one of the package
+// patterns of the preference
+func (p *Preference) Matches(pkg string) bool {
+	for _, pattern := range p.Packages {
+		switch {
+		case pattern == "*":
+			return true
+		case isRegexPattern(pattern):
+			if re, err := regexp.Compile(pattern[1 : len(pattern)-1]); err == nil && re.MatchString(pkg) {
+				return true
+			}
+		case strings.ContainsAny(pattern, "*?["):
+			if ok, _ := path.Match(pattern, pkg); ok {
+				return true
+			}
+		case pattern == pkg:
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks that the preference can be written in a preferences
+// file
+func (p *Preference) validate() error {
+	if len(p.Packages) == 0 {
+		return fmt.Errorf("missing packages")
+	}
+	for _, pattern := range p.Packages {
+		if pattern == "" || strings.ContainsAny(pattern, " \t\r\n") {
+			return fmt.Errorf("invalid package pattern '%s'", pattern)
+		}
+		if isRegexPattern(pattern) {
+			if _, err := regexp.Compile(pattern[1 : len(pattern)-1]); err != nil {
+				return fmt.Errorf("invalid package pattern '%s': %s", pattern, err)
+			}
+		} else if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid package pattern '%s': %s", pattern, err)
+		}
+	}
+	pinType, _, _ := strings.Cut(strings.TrimSpace(p.Pin), " ")
+	if !slices.Contains([]string{"release", "origin", "version"}, pinType) {
+		return fmt.Errorf("invalid pin '%s'", p.Pin)
+	}
+	if strings.ContainsAny(p.Pin, "\r\n") {
+		return fmt.Errorf("invalid pin '%s'", p.Pin)
+	}
+	return nil
+}
+
+// preference returns the preference defined by the stanza, or nil if the
+// stanza is not valid
+func (s *deb822Paragraph) preference() *Preference {
+	packages := s.getList("Package")
+	pin := s.get("Pin")
+	priority, err := strconv.Atoi(strings.TrimSpace(s.get("Pin-Priority")))
+	if len(packages) == 0 || pin == "" || err != nil {
+		return nil
+	}
+	explanation := []string{}
+	for _, item := range s.items {
+		if strings.EqualFold(item.name, "Explanation") {
+			explanation = append(explanation, item.value())
+		}
+	}
+	return &Preference{
+		Packages:    packages,
+		Pin:         pin,
+		Priority:    priority,
+		Explanation: strings.Join(explanation, "\n"),
+	}
+}
+
+// setPreference changes the stanza to define the preference, the
+// original layout of the unchanged fields is retained
+func (s *deb822Paragraph) setPreference(p *Preference) {
+	current := s.preference()
+	if current == nil || current.Explanation != p.Explanation {
+		s.del("Explanation")
+		explanation := []*deb822Item{}
+		if p.Explanation != "" {
+			for _, line := range strings.Split(p.Explanation, "\n") {
+				explanation = append(explanation, &deb822Item{name: "Explanation", lines: formatDeb822Field("Explanation: ", line, "\n")})
+			}
+		}
+		// The Explanation goes before the first field
+		idx := slices.IndexFunc(s.items, func(item *deb822Item) bool { return item.name != "" })
+		if idx == -1 {
+			idx = len(s.items)
+		}
+		s.items = slices.Insert(s.items, idx, explanation...)
+	}
+	if current == nil || !sameSet(current.Packages, p.Packages) {
+		s.set("Package", strings.Join(p.Packages, " "))
+	}
+	s.set("Pin", p.Pin)
+	s.set("Pin-Priority", strconv.Itoa(p.Priority))
+}
+
+// preferencesFiles returns the preferences files in the specified APT
+// config folder: "preferences" and the files in "preferences.d" with no
+// extension or the ".pref" extension, like apt does
+func preferencesFiles(configFolderPath string, c *config) ([]string, error) {
+	res := []string{filepath.Join(configFolderPath, "preferences")}
+	folder := filepath.Join(configFolderPath, "preferences.d")
+	list, err := fs.ReadDir(c.fs, folder)
+	if os.IsNotExist(err) {
+		return res, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s folder: %s", folder, err)
+	}
+	for _, l := range list {
+		if !l.IsDir() && isPreferencesFileName(l.Name()) {
+			res = append(res, filepath.Join(folder, l.Name()))
+		}
+	}
+	return res, nil
+}
+
+// preferencesFileNameRegexp matches the names of the files in
+// "preferences.d" read by apt, only the names with the ".pref" extension
+// may contain periods
+var preferencesFileNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$|^[A-Za-z0-9_.-]+\.pref$`)
+
+// isPreferencesFileName returns true if apt reads the file of
+// "preferences.d" with the given name
+func isPreferencesFileName(name string) bool {
+	return preferencesFileNameRegexp.MatchString(name)
+}
+
+// ParsePreferences returns the preferences defined in the specified APT
+// config folder (usually /etc/apt), in the "preferences" file and in the
+// "preferences.d" folder. Invalid stanzas are skipped.
+func ParsePreferences(configFolderPath string, opts ...Option) ([]*Preference, error) {
+	return parsePreferences(configFolderPath, newConfig(opts))
+}
+
+func parsePreferences(configFolderPath string, c *config) ([]*Preference, error) {
+	files, err := preferencesFiles(configFolderPath, c)
+	if err != nil {
+		return nil, err
+	}
+	res := []*Preference{}
+	for _, file := range files {
+		data, err := fs.ReadFile(c.fs, file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %s", file, err)
+		}
+		for i, s := range parseDeb822(data).stanzas() {
+			if p := s.preference(); p != nil {
+				p.File = file
+				p.Stanza = i + 1
+				res = append(res, p)
+			}
+		}
+	}
+	return res, nil
+}
+
+// findPreference searches the preference in the list, if its position is
+// known (File and Stanza are set) only the stanza at that position is
+// considered
+func findPreference(prefs []*Preference, pref *Preference) *Preference {
+	for _, p := range prefs {
+		if pref.File != "" && pref.Stanza != 0 && (p.File != pref.File || p.Stanza != pref.Stanza) {
+			continue
+		}
+		if p.Equals(pref) {
+			return p
+		}
+	}
+	return nil
+}
+
+// AddPreference adds the preference to the "managed.pref" file in the
+// "preferences.d" subfolder of the specified APT config folder (usually
+// /etc/apt).
+func AddPreference(pref *Preference, configFolderPath string, opts ...Option) error {
+	return changePreferences(txAdd, nil, pref, configFolderPath, newConfig(opts))
+}
+
+// EditPreference replaces the old preference with newPref
+func EditPreference(old *Preference, newPref *Preference, configFolderPath string, opts ...Option) error {
+	return changePreferences(txEdit, old, newPref, configFolderPath, newConfig(opts))
+}
+
+// RemovePreference removes the preference. A file of the "preferences.d"
+// folder left without stanzas is deleted.
+func RemovePreference(pref *Preference, configFolderPath string, opts ...Option) error {
+	return changePreferences(txRemove, pref, nil, configFolderPath, newConfig(opts))
+}
+
+// changePreferences applies the change to the preferences files while
+// holding the lock of the APT config folder. The files are written
+// like the source files (see writeSourceFiles).
+func changePreferences(kind txOpKind, old, newPref *Preference, configFolderPath string, c *config) error {
+	unlock, err := c.lock(configFolderPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	fsys, err := c.writableFS()
+	if err != nil {
+		return err
+	}
+	if newPref != nil {
+		if err := newPref.validate(); err != nil {
+			return fmt.Errorf("invalid preference: %s", err)
+		}
+	}
+	prefs, err := parsePreferences(configFolderPath, c)
+	if err != nil {
+		return fmt.Errorf("parsing APT preferences: %s", err)
+	}
+
+	var entry *Preference
+	filePath := filepath.Join(configFolderPath, "preferences.d", "managed.pref")
+	switch kind {
+	case txAdd:
+		if findPreference(prefs, newPref) != nil {
+			return fmt.Errorf("the preference is already configured")
+		}
+		if err := fsys.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			return fmt.Errorf("creating preferences folder: %s", err)
+		}
+	case txEdit:
+		if entry = findPreference(prefs, old); entry == nil {
+			return fmt.Errorf("preference doesn't exist")
+		}
+		filePath = entry.File
+	case txRemove:
+		if entry = findPreference(prefs, old); entry == nil {
+			return fmt.Errorf("preference already removed")
+		}
+		filePath = entry.File
+	}
+
+	data, err := fs.ReadFile(fsys, filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("reading %s: %s", filePath, err)
+	}
+	f := parseDeb822(data)
+	switch kind {
+	case txAdd:
+		s := &deb822Paragraph{}
+		s.setPreference(newPref)
+		f.append(s)
+	case txEdit:
+		f.stanzas()[entry.Stanza-1].setPreference(newPref)
+	case txRemove:
+		f.remove(f.stanzas()[entry.Stanza-1])
+	}
+
+	change := &fileChange{path: filePath, content: f.Bytes()}
+	if kind == txRemove && filepath.Dir(filePath) == filepath.Join(configFolderPath, "preferences.d") {
+		change.deleted = len(f.stanzas()) == 0
+	}
+	if err := writeSourceFiles(fsys, []*fileChange{change}, c.backups); err != nil {
+		return fmt.Errorf("writing of new preferences: %s", err)
+	}
+	return nil
+}
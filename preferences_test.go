@@ -0,0 +1,128 @@
+//
+//  This file is part of go-apt-client library
+//
+//  Copyright (C) 2017  Arduino AG (http://www.arduino.cc/)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+//
+
+package apt
+
+import (
+	"io/fs"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePreferences(t *testing.T) {
+	fsys := NewMemFS(map[string]string{
+		"etc/apt/preferences": "Package: *\n" +
+			"Pin: release a=stable\n" +
+			"Pin-Priority: 900\n" +
+			"\n" +
+			"# Invalid stanza\n" +
+			"Package: broken\n" +
+			"\n" +
+			"Explanation: Prefer our builds\n" +
+			"Explanation: of the gnome packages\n" +
+			"Package: gnome* /^libgnome/ nautilus\n" +
+			"Pin: origin packages.example.com\n" +
+			"Pin-Priority: 1001\n",
+		"etc/apt/preferences.d/backports.pref": "Package: *\nPin: release a=bookworm-backports\nPin-Priority: -10\n",
+		"etc/apt/preferences.d/10-my.pkg.pref": "Package: my-pkg\nPin: origin my.example.com\nPin-Priority: 600\n",
+		"etc/apt/preferences.d/no.pkg":         "Package: *\nPin: release a=unstable\nPin-Priority: 1\n",
+		"etc/apt/preferences.d/ignored.txt":    "Package: *\nPin: release a=unstable\nPin-Priority: 1\n",
+		"etc/apt/preferences.d/noext":          "Package: hello\nPin: version 2.*\nPin-Priority: 500\n",
+	})
+	prefs, err := ParsePreferences("etc/apt", WithFS(fsys))
+	require.NoError(t, err)
+	require.Equal(t, []*Preference{
+		{Packages: []string{"*"}, Pin: "release a=stable", Priority: 900, File: "etc/apt/preferences", Stanza: 1},
+		{
+			Packages:    []string{"gnome*", "/^libgnome/", "nautilus"},
+			Pin:         "origin packages.example.com",
+			Priority:    1001,
+			Explanation: "Prefer our builds\nof the gnome packages",
+			File:        "etc/apt/preferences",
+			Stanza:      3,
+		},
+		{Packages: []string{"my-pkg"}, Pin: "origin my.example.com", Priority: 600, File: "etc/apt/preferences.d/10-my.pkg.pref", Stanza: 1},
+		{Packages: []string{"*"}, Pin: "release a=bookworm-backports", Priority: -10, File: "etc/apt/preferences.d/backports.pref", Stanza: 1},
+		{Packages: []string{"hello"}, Pin: "version 2.*", Priority: 500, File: "etc/apt/preferences.d/noext", Stanza: 1},
+	}, prefs)
+
+	require.True(t, prefs[0].Matches("anything"))
+	require.True(t, prefs[1].Matches("gnome-shell"))
+	require.True(t, prefs[1].Matches("libgnome2"))
+	require.True(t, prefs[1].Matches("nautilus"))
+	require.False(t, prefs[1].Matches("nautilus-data"))
+	require.False(t, prefs[4].Matches("hello-world"))
+
+	// A missing folder is not an error
+	prefs, err = ParsePreferences("etc/apt", WithFS(NewMemFS(map[string]string{"etc/apt/sources.list": ""})))
+	require.NoError(t, err)
+	require.Empty(t, prefs)
+}
+
+func TestAddEditRemovePreference(t *testing.T) {
+	preferences := "# Distro pinning\n" +
+		"Package: *\n" +
+		"Pin: release a=stable\n" +
+		"Pin-Priority: 900\n"
+	fsys := NewMemFS(map[string]string{"etc/apt/preferences": preferences})
+
+	pref := &Preference{Packages: []string{"our-*"}, Pin: "origin packages.example.com", Priority: 1001, Explanation: "Our packages"}
+	require.NoError(t, AddPreference(pref, "etc/apt", WithFS(fsys)))
+	require.Equal(t, "Explanation: Our packages\n"+
+		"Package: our-*\n"+
+		"Pin: origin packages.example.com\n"+
+		"Pin-Priority: 1001\n", readFSFile(t, fsys, "etc/apt/preferences.d/managed.pref"))
+	require.EqualError(t, AddPreference(pref, "etc/apt", WithFS(fsys)), "the preference is already configured")
+	require.NoError(t, AddPreference(&Preference{Packages: []string{"hello"}, Pin: "version 2.*", Priority: 500}, "etc/apt", WithFS(fsys)))
+
+	prefs, err := ParsePreferences("etc/apt", WithFS(fsys))
+	require.NoError(t, err)
+	require.Len(t, prefs, 3)
+	edited := *prefs[0]
+	edited.Priority = 990
+	edited.Explanation = "Stable first"
+	require.NoError(t, EditPreference(prefs[0], &edited, "etc/apt", WithFS(fsys)))
+	require.Equal(t, "# Distro pinning\n"+
+		"Explanation: Stable first\n"+
+		"Package: *\n"+
+		"Pin: release a=stable\n"+
+		"Pin-Priority: 990\n", readFSFile(t, fsys, "etc/apt/preferences"))
+	require.Equal(t, preferences, readFSFile(t, fsys, "etc/apt/preferences.save"))
+	require.EqualError(t, EditPreference(prefs[0], &edited, "etc/apt", WithFS(fsys)), "preference doesn't exist")
+
+	// The file is deleted with its last stanza
+	require.NoError(t, RemovePreference(prefs[1], "etc/apt", WithFS(fsys)))
+	require.Equal(t, "Package: hello\n"+
+		"Pin: version 2.*\n"+
+		"Pin-Priority: 500\n", readFSFile(t, fsys, "etc/apt/preferences.d/managed.pref"))
+	require.EqualError(t, RemovePreference(prefs[1], "etc/apt", WithFS(fsys)), "preference already removed")
+	require.NoError(t, RemovePreference(&Preference{Packages: []string{"hello"}, Pin: "version 2.*", Priority: 500}, "etc/apt", WithFS(fsys)))
+	_, err = fs.Stat(fsys, "etc/apt/preferences.d/managed.pref")
+	require.True(t, os.IsNotExist(err))
+
+	for _, invalid := range []*Preference{
+		{Pin: "release a=stable", Priority: 1},
+		{Packages: []string{"/[/"}, Pin: "release a=stable", Priority: 1},
+		{Packages: []string{"a["}, Pin: "release a=stable", Priority: 1},
+		{Packages: []string{"*"}, Pin: "a=stable", Priority: 1},
+	} {
+		require.ErrorContains(t, AddPreference(invalid, "etc/apt", WithFS(fsys)), "invalid preference: ")
+	}
+}
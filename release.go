@@ -0,0 +1,144 @@
+//
+//  This file is part of go-apt-client library
+//
+//  Copyright (C) 2017  Arduino AG (http://www.arduino.cc/)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+//
+
+package apt
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// officialHosts are the domains of the Debian and Ubuntu archives and
+// mirrors, the repositories on other hosts are third-party
+var officialHosts = []string{"debian.org", "ubuntu.com"}
+
+// upgradeCommentRegexp matches the comment added to the entries disabled
+// by a release upgrade
+var upgradeCommentRegexp = regexp.MustCompile(`\s*disabled on upgrade to \S+\s*$`)
+
+// WithReenableUpgradeDisabled makes SwitchRelease enable again the
+// entries disabled by a previous release upgrade (the ones commented as
+// "disabled on upgrade to ...").
+func WithReenableUpgradeDisabled() Option {
+	return func(c *config) {
+		c.reenableUpgradeDisabled = true
+	}
+}
+
+// ReleaseSwitch is the set of changes that switch the APT configuration
+// to another release
+type ReleaseSwitch struct {
+	From string
+	To   string
+	// Changed are the rewritten repositories
+	Changed []*RepositoryChange
+	// ThirdParty are the rewritten repositories that are not part of the
+	// Debian or Ubuntu archives, and that may not support the new release
+	ThirdParty RepositoryList
+
+	tx *Transaction
+}
+
+// PlanReleaseSwitch computes the changes that switch the repositories in
+// the specified APT config folder (usually /etc/apt) from the release
+// with codename from to the release with codename to: the suites like
+// "bookworm", "bookworm-updates" and "bookworm-security" are rewritten as
+// "trixie", "trixie-updates" and "trixie-security". Nothing is written
+// until Apply is called.
+func PlanReleaseSwitch(from, to string, configFolderPath string, opts ...Option) (*ReleaseSwitch, error) {
+	for _, codename := range []string{from, to} {
+		if codename == "" || strings.ContainsAny(codename, " \t/-#") {
+			return nil, fmt.Errorf("invalid codename '%s'", codename)
+		}
+	}
+	if from == to {
+		return nil, fmt.Errorf("the releases are the same")
+	}
+	c := newConfig(opts)
+	repos, _, err := parseAPTConfigFolder(configFolderPath, ParseLenient, c)
+	if err != nil {
+		return nil, fmt.Errorf("parsing APT config: %s", err)
+	}
+
+	res := &ReleaseSwitch{From: from, To: to, Changed: []*RepositoryChange{}, ThirdParty: RepositoryList{}, tx: newTransaction(configFolderPath, c)}
+	for _, repo := range repos {
+		newRepo := *repo
+		if suite, suffix := suiteCodename(repo.Distribution); suite == from && !repo.IsFlat() {
+			newRepo.Distribution = to + suffix
+		}
+		if c.reenableUpgradeDisabled && !repo.Enabled && upgradeCommentRegexp.MatchString(repo.Comment) {
+			newRepo.Enabled = true
+			newRepo.Comment = upgradeCommentRegexp.ReplaceAllString(repo.Comment, "")
+		}
+		fields := repo.changedFields(&newRepo)
+		if len(fields) == 0 {
+			continue
+		}
+		res.Changed = append(res.Changed, &RepositoryChange{Old: repo, New: &newRepo, Fields: fields})
+		res.tx.EditRepository(repo, &newRepo)
+		if !isOfficialURI(repo.URI) {
+			res.ThirdParty = append(res.ThirdParty, repo)
+		}
+	}
+	return res, nil
+}
+
+// isOfficialURI returns true if the URI points to the Debian or Ubuntu
+// archives or their mirrors
+func isOfficialURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, h := range officialHosts {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+	return false
+}
+
+// Preview returns the changes that Apply would make, without writing
+// anything.
+func (s *ReleaseSwitch) Preview() ([]*FileDiff, error) {
+	return s.tx.Preview()
+}
+
+// Apply writes all the changes together: either all the repositories are
+// switched or, on error, none of them.
+func (s *ReleaseSwitch) Apply() error {
+	return s.tx.Commit()
+}
+
+// SwitchRelease switches the repositories in the specified APT config
+// folder (usually /etc/apt) from the release with codename from to the
+// release with codename to, see PlanReleaseSwitch. The returned
+// ReleaseSwitch reports the changes made.
+func SwitchRelease(from, to string, configFolderPath string, opts ...Option) (*ReleaseSwitch, error) {
+	res, err := PlanReleaseSwitch(from, to, configFolderPath, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := res.Apply(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
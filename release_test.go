@@ -0,0 +1,101 @@
+//
+//  This file is part of go-apt-client library
+//
+//  Copyright (C) 2017  Arduino AG (http://www.arduino.cc/)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+//
+
+package apt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSwitchRelease(t *testing.T) {
+	fsys := NewMemFS(map[string]string{
+		"etc/apt/sources.list": "deb https://deb.debian.org/debian bookworm main\n" +
+			"deb https://security.debian.org/debian-security bookworm-security main\n" +
+			"# deb https://deb.debian.org/debian bookworm-backports main # disabled on upgrade to bookworm\n" +
+			"deb https://deb.debian.org/debian sid main\n" +
+			"deb https://example.com/repo ./\n",
+		"etc/apt/sources.list.d/debian.sources": "Types: deb\n" +
+			"URIs: https://deb.debian.org/debian\n" +
+			"Suites: bookworm-updates\n" +
+			"Components: main\n" +
+			"\n" +
+			"Types: deb\n" +
+			"URIs: https://download.example.com/apt\n" +
+			"Suites: bookworm\n" +
+			"Components: stable\n",
+	})
+
+	plan, err := PlanReleaseSwitch("bookworm", "trixie", "etc/apt", WithFS(fsys))
+	require.NoError(t, err)
+	require.Len(t, plan.Changed, 5)
+	require.Equal(t, []*FieldChange{{Field: "Distribution", Old: "bookworm-security", New: "trixie-security"}}, plan.Changed[1].Fields)
+	require.Len(t, plan.ThirdParty, 1)
+	require.Equal(t, "https://download.example.com/apt", plan.ThirdParty[0].URI)
+	diffs, err := plan.Preview()
+	require.NoError(t, err)
+	require.Len(t, diffs, 2)
+
+	res, err := SwitchRelease("bookworm", "trixie", "etc/apt", WithFS(fsys), WithReenableUpgradeDisabled())
+	require.NoError(t, err)
+	require.Len(t, res.Changed, 5)
+	require.Equal(t, "deb https://deb.debian.org/debian trixie main\n"+
+		"deb https://security.debian.org/debian-security trixie-security main\n"+
+		"deb https://deb.debian.org/debian trixie-backports main\n"+
+		"deb https://deb.debian.org/debian sid main\n"+
+		"deb https://example.com/repo ./\n", readFSFile(t, fsys, "etc/apt/sources.list"))
+	require.Equal(t, "Types: deb\n"+
+		"URIs: https://deb.debian.org/debian\n"+
+		"Suites: trixie-updates\n"+
+		"Components: main\n"+
+		"\n"+
+		"Types: deb\n"+
+		"URIs: https://download.example.com/apt\n"+
+		"Suites: trixie\n"+
+		"Components: stable\n", readFSFile(t, fsys, "etc/apt/sources.list.d/debian.sources"))
+
+	// Nothing left to switch
+	res, err = SwitchRelease("bookworm", "trixie", "etc/apt", WithFS(fsys))
+	require.NoError(t, err)
+	require.Empty(t, res.Changed)
+
+	_, err = SwitchRelease("trixie", "trixie", "etc/apt", WithFS(fsys))
+	require.EqualError(t, err, "the releases are the same")
+	_, err = SwitchRelease("trixie", "forky-updates", "etc/apt", WithFS(fsys))
+	require.EqualError(t, err, "invalid codename 'forky-updates'")
+
+	// A stanza with several types and suites, like the default one of
+	// Ubuntu 24.04
+	fsys = NewMemFS(map[string]string{
+		"etc/apt/sources.list": "",
+		"etc/apt/sources.list.d/ubuntu.sources": "Types: deb deb-src\n" +
+			"URIs: http://archive.ubuntu.com/ubuntu\n" +
+			"Suites: noble noble-updates noble-backports\n" +
+			"Components: main universe\n",
+	})
+	res, err = SwitchRelease("noble", "plucky", "etc/apt", WithFS(fsys))
+	require.NoError(t, err)
+	require.Len(t, res.Changed, 6)
+	repos, err := ParseAPTConfigFolder("etc/apt", WithFS(fsys))
+	require.NoError(t, err)
+	require.Len(t, repos, 6)
+	for _, repo := range repos {
+		require.Contains(t, []string{"plucky", "plucky-updates", "plucky-backports"}, repo.Distribution)
+	}
+}
@@ -19,12 +19,13 @@
 package apt
 
 import (
-	"bufio"
-	"bytes"
 	"fmt"
+	"io/fs"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
-	"regexp"
+	"slices"
 	"strings"
 )
 
@@ -37,6 +38,18 @@ func (r RepositoryList) Contains(repo *Repository) bool {
 	return r.Find(repo) != nil
 }
 
+// Overlapping returns the repositories of the RepositoryList that overlap
+// with the one passed as parameter (see Repository.Overlaps)
+func (r RepositoryList) Overlapping(repo *Repository) RepositoryList {
+	res := RepositoryList{}
+	for _, other := range r {
+		if repo.Overlaps(other) {
+			res = append(res, other)
+		}
+	}
+	return res
+}
+
 // Find search in the RepositoryList a repo that has the same
 // metadata as the one passed as parameter
 func (r RepositoryList) Find(repoToFind *Repository) *Repository {
@@ -48,41 +61,157 @@ func (r RepositoryList) Find(repoToFind *Repository) *Repository {
 	return nil
 }
 
+// findEntry search in the RepositoryList the entry that defines the
+// repository passed as parameter. If the position of the repository is
+// known (File and Line are set) only the entry at that position is
+// considered, otherwise the first repository with the same metadata
+// is returned.
+func (r RepositoryList) findEntry(repoToFind *Repository) *Repository {
+	if repoToFind.File == "" || repoToFind.Line == 0 {
+		return r.Find(repoToFind)
+	}
+	for _, repo := range r {
+		if repo.File == repoToFind.File && repo.Line == repoToFind.Line && repo.Stanza == repoToFind.Stanza && repoToFind.Equals(repo) {
+			return repo
+		}
+	}
+	return nil
+}
+
 // Repository contains metadata about a repository installed in the system
 type Repository struct {
-	Enabled      bool
-	SourceRepo   bool
-	Options      string
-	URI          string
+	Enabled    bool
+	SourceRepo bool
+	Options    RepositoryOptions
+	URI        string
+	// Distribution is the suite of the repository, for flat repositories
+	// it is a path relative to URI ending with "/" (like "./")
 	Distribution string
-	Components   string
-	Comment      string
+	// Components is empty for flat repositories
+	Components string
+	Comment    string
+
+	// File is the path of the source file that defines the repository
+	File string `json:",omitempty"`
+	// Line is the line number (starting from 1) of the repository entry
+	// in File, for deb822 files it is the first line of the stanza
+	Line int `json:",omitempty"`
+	// Stanza is the index (starting from 1) of the stanza that defines
+	// the repository in deb822 files, it is 0 for one-line files
+	Stanza int `json:",omitempty"`
+	// Raw is the original text of the entry (the whole stanza for deb822
+	// files), with the passwords of the URIs redacted
+	Raw string `json:",omitempty"`
+}
+
+// IsFlat returns true if the repository is a flat repository, that is
+// a repository without the "dists" hierarchy where the Distribution is
+// a path ending with "/" and there are no components.
+func (r *Repository) IsFlat() bool {
+	return strings.HasSuffix(r.Distribution, "/")
+}
 
-	configFile string
+// flatLocation returns the URL of the folder of a flat repository
+func (r *Repository) flatLocation() string {
+	dist := strings.TrimPrefix(r.Distribution, ".")
+	dist = strings.TrimPrefix(dist, "/")
+	return canonicalURI(strings.TrimSuffix(r.URI, "/") + "/" + dist)
+}
+
+// defaultPorts are the ports that can be omitted from the URIs
+var defaultPorts = map[string]string{"http": "80", "https": "443", "ftp": "21"}
+
+// canonicalURI returns the URI in a canonical form, so that equivalent
+// URIs (for example "HTTP://Example.com:80/debian/" and
+// "http://example.com/debian") are equal
+func canonicalURI(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Opaque != "" {
+		return strings.TrimRight(uri, "/")
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port := u.Port(); port != "" && port != defaultPorts[u.Scheme] {
+		host += ":" + port
+	}
+	u.Host = host
+	// The credentials don't change the repository
+	u.User = nil
+	if u.Path != "" {
+		u.Path = strings.TrimRight(path.Clean(u.Path), "/")
+		u.RawPath = ""
+	}
+	return u.String()
+}
+
+// components returns the set of the components of the repository
+func (r *Repository) components() []string {
+	return slices.Compact(slices.Sorted(slices.Values(strings.Fields(r.Components))))
 }
 
 // Equals check if the Repository metadata are equivalent to the
 // one provided as parameter. Two Repository are equivalent if all
-// metadata matches with the exception of Enabled and Comment.
+// metadata matches with the exception of Enabled, Comment and the
+// position in the source files. The URIs are compared in their canonical
+// form, and the order of the components and of the options is not
+// relevant. Flat repositories are equivalent if they point to the same
+// folder (for example "http://host/repo ./" and "http://host/ repo/").
 func (r *Repository) Equals(repo *Repository) bool {
-	if r.Components != repo.Components {
+	if r.IsFlat() || repo.IsFlat() {
+		return r.IsFlat() && repo.IsFlat() &&
+			r.flatLocation() == repo.flatLocation() &&
+			r.SourceRepo == repo.SourceRepo &&
+			r.Options.Equals(repo.Options)
+	}
+	if !slices.Equal(r.components(), repo.components()) {
 		return false
 	}
 	if r.Distribution != repo.Distribution {
 		return false
 	}
-	if r.URI != repo.URI {
+	if canonicalURI(r.URI) != canonicalURI(repo.URI) {
 		return false
 	}
 	if r.SourceRepo != repo.SourceRepo {
 		return false
 	}
-	if r.Options != repo.Options {
+	if !r.Options.Equals(repo.Options) {
 		return false
 	}
 	return true
 }
 
+// Overlaps check if the Repository shares some indexes with the one
+// provided as parameter: they have the same type, URI and distribution
+// and at least one component in common, regardless of the options. For
+// example "http://host/debian stable main" overlaps with
+// "http://host/debian stable main contrib".
+func (r *Repository) Overlaps(repo *Repository) bool {
+	if r.SourceRepo != repo.SourceRepo {
+		return false
+	}
+	if r.IsFlat() || repo.IsFlat() {
+		return r.IsFlat() && repo.IsFlat() && r.flatLocation() == repo.flatLocation()
+	}
+	if r.Distribution != repo.Distribution || canonicalURI(r.URI) != canonicalURI(repo.URI) {
+		return false
+	}
+	components := repo.components()
+	return slices.ContainsFunc(r.components(), func(c string) bool {
+		return slices.Contains(components, c)
+	})
+}
+
+func (r *Repository) sourceType() string {
+	if r.SourceRepo {
+		return "deb-src"
+	}
+	return "deb"
+}
+
 // APTConfigLine returns the "deb" or "deb-src" config line to put in
 // source.list to install the Repository
 func (r *Repository) APTConfigLine() string {
@@ -95,226 +224,367 @@ func (r *Repository) APTConfigLine() string {
 	} else {
 		res += "deb "
 	}
-	if strings.TrimSpace(r.Options) != "" {
-		res += "[" + r.Options + "]"
+	if !r.Options.IsEmpty() {
+		res += "[" + r.Options.String() + "] "
+	}
+	res += r.URI + " " + r.Distribution
+	if r.Components != "" {
+		res += " " + r.Components
 	}
-	res += r.URI + " " + r.Distribution + " " + r.Components
 	if strings.TrimSpace(r.Comment) != "" {
 		res += " # " + r.Comment
 	}
 	return res
 }
 
-var aptConfigLineRegexp = regexp.MustCompile(`^(# )?(deb|deb-src)(?: \[(.*)\])? ([^ ]+) ([^ ]+) ([^#\n]+)(?: +# *(.*))?$`)
+// String returns the config line of the repository, like APTConfigLine,
+// with the password in the URI redacted
+func (r *Repository) String() string {
+	res := *r
+	res.URI = redactURI(r.URI)
+	return res.APTConfigLine()
+}
 
-func parseAPTConfigLine(line string) *Repository {
-	match := aptConfigLineRegexp.FindAllStringSubmatch(line, -1)
-	if len(match) == 0 || len(match[0]) < 6 {
-		return nil
+func parseAPTConfigFile(fsys fs.FS, configPath string) (RepositoryList, []*Diagnostic, error) {
+	file, err := readSourceFile(fsys, configPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	res := file.repositories()
+	for _, repo := range res {
+		repo.File = configPath
 	}
-	fields := match[0]
-	return &Repository{
-		Enabled:      fields[1] != "# ",
-		SourceRepo:   fields[2] == "deb-src",
-		Options:      fields[3],
-		URI:          fields[4],
-		Distribution: fields[5],
-		Components:   fields[6],
-		Comment:      fields[7],
+	diagnostics := file.diagnostics()
+	for _, d := range diagnostics {
+		d.File = configPath
 	}
+	return res, diagnostics, nil
 }
 
-func parseAPTConfigFile(configPath string) (RepositoryList, error) {
-	data, err := os.ReadFile(configPath)
+func readSourceFile(fsys fs.FS, configPath string) (sourceFile, error) {
+	data, err := fs.ReadFile(fsys, configPath)
 	if err != nil {
 		return nil, fmt.Errorf("reading %s: %s", configPath, err)
 	}
-	scanner := bufio.NewScanner(bytes.NewReader(data))
-
-	res := RepositoryList{}
-	for scanner.Scan() {
-		line := scanner.Text()
-		repo := parseAPTConfigLine(line)
-		if repo != nil {
-			repo.configFile = configPath
-			res = append(res, repo)
-		}
-	}
-	return res, nil
+	return parseSourceFile(configPath, data), nil
 }
 
 // ParseAPTConfigFolder scans an APT config folder (usually /etc/apt) to
 // get information about all configured repositories, it scans also
-// "source.list.d" subfolder to find all the "*.list" files.
-func ParseAPTConfigFolder(folderPath string) (RepositoryList, error) {
+// "source.list.d" subfolder to find all the "*.list" files and the
+// deb822-style "*.sources" files. Malformed entries are skipped, use
+// ParseAPTConfigFolderWithDiagnostics to get them reported.
+func ParseAPTConfigFolder(folderPath string, opts ...Option) (RepositoryList, error) {
+	res, _, err := ParseAPTConfigFolderWithDiagnostics(folderPath, ParseLenient, opts...)
+	return res, err
+}
+
+// ParseAPTConfigFolderWithDiagnostics is like ParseAPTConfigFolder but it
+// also returns the diagnostics about the malformed entries found. In
+// ParseStrict mode a *ParseError is returned if any entry that apt would
+// reject is found.
+func ParseAPTConfigFolderWithDiagnostics(folderPath string, mode ParseMode, opts ...Option) (RepositoryList, []*Diagnostic, error) {
+	return parseAPTConfigFolder(folderPath, mode, newConfig(opts))
+}
+
+func parseAPTConfigFolder(folderPath string, mode ParseMode, c *config) (RepositoryList, []*Diagnostic, error) {
 	sources := []string{filepath.Join(folderPath, "sources.list")}
 
 	sourcesFolder := filepath.Join(folderPath, "sources.list.d")
-	list, err := os.ReadDir(sourcesFolder)
+	list, err := fs.ReadDir(c.fs, sourcesFolder)
 	if err != nil {
-		return nil, fmt.Errorf("reading %s folder: %s", sourcesFolder, err)
+		return nil, nil, fmt.Errorf("reading %s folder: %s", sourcesFolder, err)
 	}
 	for _, l := range list {
-		if strings.HasSuffix(l.Name(), ".list") {
+		if strings.HasSuffix(l.Name(), ".list") || strings.HasSuffix(l.Name(), ".sources") {
 			sources = append(sources, filepath.Join(sourcesFolder, l.Name()))
 		}
 	}
 
 	res := RepositoryList{}
+	diagnostics := []*Diagnostic{}
 	for _, source := range sources {
-		repos, err := parseAPTConfigFile(source)
+		repos, diags, err := parseAPTConfigFile(c.fs, source)
 		if err != nil {
-			return nil, fmt.Errorf("parsing %s: %s", source, err)
+			return nil, nil, fmt.Errorf("parsing %s: %s", source, err)
 		}
 		res = append(res, repos...)
+		diagnostics = append(diagnostics, diags...)
+	}
+	if mode == ParseStrict {
+		if err := newParseError(diagnostics); err != nil {
+			return nil, diagnostics, err
+		}
 	}
-	return res, nil
+	return res, diagnostics, nil
+}
+
+func isDeb822File(path string) bool {
+	return strings.HasSuffix(path, ".sources")
 }
 
 // AddRepository adds the specified repository by changing the specified APT
 // config folder (usually /etc/apt). The new repository is saved into
-// a file named "managed.list"
-func AddRepository(repo *Repository, configFolderPath string) error {
-	repos, err := ParseAPTConfigFolder(configFolderPath)
-	if err != nil {
-		return fmt.Errorf("parsing APT config: %s", err)
-	}
-	if repos.Contains(repo) {
-		return fmt.Errorf("the repository is already configured")
-	}
-
-	// Add to the "managed.list" file
-	managedPath := filepath.Join(configFolderPath, "sources.list.d", "managed.list")
-	f, err := os.OpenFile(managedPath, os.O_APPEND|os.O_WRONLY, 0644)
-	if os.IsNotExist(err) {
-		f, err = os.OpenFile(managedPath, os.O_CREATE|os.O_WRONLY, 0644)
-	}
-	if err != nil {
-		return fmt.Errorf("opening %s: %s", managedPath, err)
-	}
-	defer f.Close() //nolint:errcheck
-	if _, err = f.WriteString(repo.APTConfigLine() + "\n"); err != nil {
-		return fmt.Errorf("writing repo data to config file %s: %s", managedPath, err)
-	}
-	return nil
+// a file named "managed.list", or appended as a new stanza to
+// "managed.sources" if the latter already exists, unless another file is
+// selected with WithTargetFile. The credentials in the URI, if any, are
+// moved to "auth.conf.d/managed.conf" (see SetAuthEntry). The options
+// that can't be written in the one-line format, like an embedded
+// signed-by key, are accepted only in a ".sources" file.
+func AddRepository(repo *Repository, configFolderPath string, opts ...Option) error {
+	tx := NewTransaction(configFolderPath, opts...)
+	tx.AddRepository(repo)
+	return tx.Commit()
 }
 
 // RemoveRepository removes a repository from the repository list files
-// found in the specified APT config folder (usually /etc/apt)
-func RemoveRepository(repo *Repository, configFolderPath string) error {
-	// Read all repos configurations
-	repos, err := ParseAPTConfigFolder(configFolderPath)
-	if err != nil {
-		return fmt.Errorf("parsing APT config: %s", err)
-	}
+// found in the specified APT config folder (usually /etc/apt). A file of
+// the "sources.list.d" folder left without entries is deleted.
+func RemoveRepository(repo *Repository, configFolderPath string, opts ...Option) error {
+	tx := NewTransaction(configFolderPath, opts...)
+	tx.RemoveRepository(repo)
+	return tx.Commit()
+}
 
-	// Find the repo to remove
-	repoToRemove := repos.Find(repo)
-	if repoToRemove == nil {
-		return fmt.Errorf("repository already removed")
-	}
+// EditRepository replace an old repo configuration with a new repo
+// configuration in the specified APT config folder (usually /etc/apt).
+func EditRepository(old *Repository, newRepo *Repository, configFolderPath string, opts ...Option) error {
+	tx := NewTransaction(configFolderPath, opts...)
+	tx.EditRepository(old, newRepo)
+	return tx.Commit()
+}
 
-	// Read the config file that contains the repo config to remove
-	fileToFilter := repoToRemove.configFile
-	data, err := os.ReadFile(fileToFilter)
-	if err != nil {
-		return fmt.Errorf("reading config file %s: %s", fileToFilter, err)
-	}
+// EnableRepository enables a disabled repository in the specified APT
+// config folder (usually /etc/apt): one-line entries are uncommented and
+// the "Enabled: no" field is removed from deb822 stanzas. Nothing is
+// changed if the repository is already enabled.
+func EnableRepository(repo *Repository, configFolderPath string, opts ...Option) error {
+	tx := NewTransaction(configFolderPath, opts...)
+	tx.EnableRepository(repo)
+	return tx.Commit()
+}
 
-	// Create the new version of the file
-	scanner := bufio.NewScanner(bytes.NewReader(data))
-	newContent := ""
-	for scanner.Scan() {
-		line := scanner.Text()
-		r := parseAPTConfigLine(line)
-		if r != nil && r.Equals(repo) {
-			// Filter repo configs that match the repo to be removed
+// DisableRepository disables a repository in the specified APT config
+// folder (usually /etc/apt): one-line entries are commented out and the
+// "Enabled: no" field is added to deb822 stanzas. Nothing is changed if
+// the repository is already disabled.
+func DisableRepository(repo *Repository, configFolderPath string, opts ...Option) error {
+	tx := NewTransaction(configFolderPath, opts...)
+	tx.DisableRepository(repo)
+	return tx.Commit()
+}
+
+// writeSourceFiles saves the new content of the given files, the files
+// are created if they don't exist yet (readable only by the owner if
+// secret). Either all the files are changed or, if any of them can't be
+// written, none of them: the files already replaced are restored from
+// their previous version. Each file is atomically replaced by its new
+// version, so it's never missing even if the process is interrupted. The
+// replaced files keep their permissions, owner and extended attributes,
+// and the given number of backup copies is kept (see WithBackups). When
+// the credentials of an URI are removed from a file no backup copy of its
+// previous version is kept, and the older copies with those credentials
+// are deleted, so that the passwords don't stay readable by everyone.
+func writeSourceFiles(fsys WritableFS, changes []*fileChange, backups int) error {
+	durable, _ := fsys.(durableFS)
+
+	// Create the new version of all the files
+	removedCredentials := map[string][]string{}
+	for _, change := range changes {
+		path := change.path
+		if old, err := fs.ReadFile(fsys, path); err == nil && !change.secret {
+			removedCredentials[path] = uriCredentialsRemoved(string(old), string(change.content))
+		}
+		if change.deleted {
 			continue
 		}
-		newContent += line + "\n"
+		perm := fs.FileMode(0644)
+		if change.secret {
+			perm = 0600
+		}
+		info, err := fs.Stat(fsys, path)
+		if err == nil {
+			perm = info.Mode().Perm()
+		}
+		if err := fsys.WriteFile(path+".new", change.content, perm); err != nil {
+			return fmt.Errorf("creating replacement file for %s: %s", path, err)
+		}
+		// Only in case of error clean-up the new copy (otherwise ignore the error...)
+		defer fsys.Remove(path + ".new") //nolint:errcheck
+		if durable != nil && info != nil {
+			if err := durable.copyMetadata(path, path+".new"); err != nil {
+				return fmt.Errorf("copying permissions of %s: %s", path, err)
+			}
+		}
 	}
 
-	err = replaceFile(fileToFilter, []byte(newContent))
-	if err != nil {
-		return fmt.Errorf("writing of new config: %s", err)
-	}
+	replaced := []string{}
+	created := []string{}
+	rollback := func(err error) error {
+		for _, path := range created {
+			if rbErr := fsys.Remove(path); rbErr != nil {
+				return fmt.Errorf("%s (rolling back %s: %s)", err, path, rbErr)
+			}
+		}
+		for _, path := range replaced {
+			if rbErr := fsys.Rename(path+".orig", path); rbErr != nil {
+				return fmt.Errorf("%s (rolling back previous version of %s: %s)", err, path, rbErr)
+			}
+			// A hard link to the same file is not renamed
+			fsys.Remove(path + ".orig") //nolint:errcheck
+		}
+		return err
+	}
+	folders := []string{}
+	for _, change := range changes {
+		path := change.path
+		newPath := path + ".new"
+		oldPath := path + ".orig"
+		exists := true
+		if _, err := fs.Stat(fsys, path); os.IsNotExist(err) {
+			exists = false
+		}
+		if !slices.Contains(folders, filepath.Dir(path)) {
+			folders = append(folders, filepath.Dir(path))
+		}
 
-	return nil
-}
+		// Keep aside the previous version, deleted files are only moved.
+		// The other files are copied, so that the file is always present
+		// and the new copy atomically replaces it.
+		if exists && change.deleted {
+			if err := fsys.Rename(path, oldPath); err != nil {
+				return rollback(fmt.Errorf("moving previous version of %s: %s", path, err))
+			}
+			replaced = append(replaced, path)
+		}
+		if change.deleted {
+			continue
+		}
+		if exists {
+			if err := backupCopy(fsys, path, oldPath); err != nil {
+				return rollback(fmt.Errorf("copying previous version of %s: %s", path, err))
+			}
+			replaced = append(replaced, path)
+		}
 
-// EditRepository replace an old repo configuration with a new repo
-// configuration in the specified APT config folder (usually /etc/apt).
-func EditRepository(old *Repository, newRepo *Repository, configFolderPath string) error {
-	// Read all repos configurations
-	repos, err := ParseAPTConfigFolder(configFolderPath)
-	if err != nil {
-		return fmt.Errorf("parsing APT config: %s", err)
+		// Rename the new copy to the final path
+		if err := fsys.Rename(newPath, path); err != nil {
+			return rollback(fmt.Errorf("renaming %s to %s: %s", newPath, path, err))
+		}
+		if !exists {
+			created = append(created, path)
+		}
 	}
-
-	// Find the repo to edit
-	repoToEdit := repos.Find(old)
-	if repoToEdit == nil {
-		return fmt.Errorf("repository doesn't exist")
+	if durable != nil {
+		for _, folder := range folders {
+			if err := durable.syncDir(folder); err != nil {
+				return rollback(fmt.Errorf("syncing folder %s: %s", folder, err))
+			}
+		}
 	}
 
-	// Read the config file that contains the repo configuration to edit
-	fileToEdit := repoToEdit.configFile
-	data, err := os.ReadFile(fileToEdit)
-	if err != nil {
-		return fmt.Errorf("reading config file %s: %s", fileToEdit, err)
-	}
-
-	// Create the new version of the file
-	scanner := bufio.NewScanner(bytes.NewReader(data))
-	newContent := ""
-	for scanner.Scan() {
-		line := scanner.Text()
-		r := parseAPTConfigLine(line)
-		if r.Equals(old) {
-			// Write the new config to replace the old one
-			newContent += newRepo.APTConfigLine() + "\n"
+	// The new configuration is in place, now rotate the backup copies
+	for _, path := range replaced {
+		if credentials := removedCredentials[path]; len(credentials) > 0 {
+			if err := removeBackups(fsys, path, path+".orig", credentials, backups); err != nil {
+				return fmt.Errorf("removing backup copies of %s: %s", path, err)
+			}
 			continue
 		}
-		newContent += line + "\n"
+		// The previous version, a hard link or the moved file, still has
+		// the old modification time: the backup copy is made now
+		if touch, ok := fsys.(touchFS); ok && backups > 0 {
+			if err := touch.touch(path + ".orig"); err != nil {
+				return fmt.Errorf("keeping backup copy of %s: %s", path, err)
+			}
+		}
+		if err := keepBackup(fsys, path, path+".orig", backups); err != nil {
+			return fmt.Errorf("keeping backup copy of %s: %s", path, err)
+		}
 	}
+	return nil
+}
 
-	err = replaceFile(fileToEdit, []byte(newContent))
-	if err != nil {
-		return fmt.Errorf("writing of new config: %s", err)
+// uriCredentialsRemoved returns the credentials of the URIs in the old
+// content of a file that are not in its new content
+func uriCredentialsRemoved(oldContent, newContent string) []string {
+	res := []string{}
+	for _, credentials := range uriPasswordRegexp.FindAllString(oldContent, -1) {
+		if !strings.Contains(newContent, credentials) && !slices.Contains(res, credentials) {
+			res = append(res, credentials)
+		}
 	}
+	return res
+}
 
+// removeBackups deletes the previous version of the file, saved in the
+// file oldPath, and the backup copies that contain any of the given
+// credentials
+func removeBackups(fsys WritableFS, path, oldPath string, credentials []string, backups int) error {
+	if err := fsys.Remove(oldPath); err != nil {
+		return err
+	}
+	for i := range backups {
+		data, err := fs.ReadFile(fsys, backupPath(path, i))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if slices.ContainsFunc(credentials, func(c string) bool { return strings.Contains(string(data), c) }) {
+			if err := fsys.Remove(backupPath(path, i)); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
-func replaceFile(path string, newContent []byte) error {
-	newPath := path + ".new"
-	backupPath := path + ".save"
-
-	// Create the new version of the file
-	err := os.WriteFile(newPath, newContent, 0600)
-	if err != nil {
-		return fmt.Errorf("creating replacement file for %s: %s", newPath, err)
+// backupCopy makes the copy oldPath of the file path, with a hard link
+// if the file system supports it
+func backupCopy(fsys WritableFS, path, oldPath string) error {
+	// A copy left by an interrupted change is replaced
+	if err := fsys.Remove(oldPath); err != nil && !os.IsNotExist(err) {
+		return err
 	}
-
-	// Only in case of error clean-up the new copy (otherwise ignore the error...)
-	defer os.Remove(newPath) //nolint:errcheck
-
-	// Make a backup copy
-	err = os.Rename(path, backupPath)
+	durable, _ := fsys.(durableFS)
+	if durable != nil && durable.link(path, oldPath) == nil {
+		return nil
+	}
+	data, err := fs.ReadFile(fsys, path)
 	if err != nil {
-		return fmt.Errorf("making backup copy of %s: %s", path, err)
+		return err
 	}
-
-	// Rename the new copy to the final path
-	err = os.Rename(newPath, path)
+	info, err := fs.Stat(fsys, path)
 	if err != nil {
-		// Something went wrong... try to rollback the backup
-		err := os.Rename(backupPath, path)
-		if err != nil {
-			return fmt.Errorf("rolling back backup copy of %s: %s", path, err)
-		}
-		return fmt.Errorf("renaming %s to %s: %s", newPath, path, err)
+		return err
+	}
+	if err := fsys.WriteFile(oldPath, data, info.Mode().Perm()); err != nil {
+		return err
 	}
+	if durable != nil {
+		return durable.copyMetadata(path, oldPath)
+	}
+	return nil
+}
 
+// validate checks that the repository can be written in a source file
+func (r *Repository) validate() error {
+	if r.URI == "" {
+		return fmt.Errorf("missing URI")
+	}
+	if r.Distribution == "" {
+		return fmt.Errorf("missing distribution")
+	}
+	if strings.ContainsAny(r.URI+r.Distribution, " \t\r\n#") {
+		return fmt.Errorf("invalid URI or distribution")
+	}
+	if r.IsFlat() && r.Components != "" {
+		return fmt.Errorf("components are not allowed in flat repositories")
+	}
+	if !r.IsFlat() && strings.TrimSpace(r.Components) == "" {
+		return fmt.Errorf("missing components")
+	}
 	return nil
 }
@@ -21,6 +21,7 @@ package apt
 import (
 	"encoding/json"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -39,17 +40,18 @@ func TestParseAPTConfigFolder(t *testing.T) {
 	expected := []*Repository{}
 	err = json.Unmarshal(expectedData, &expected)
 	require.NoError(t, err, "Decoding expected data")
+	require.Len(t, repos, len(expected))
 
 	for i, repo := range repos {
-		assert.Empty(t, cmp.Diff(expected[i], repo, cmpopts.IgnoreFields(Repository{}, "configFile")))
+		assert.Empty(t, cmp.Diff(expected[i], repo,
+			cmpopts.IgnoreFields(Repository{}, "File", "Line", "Stanza", "Raw"),
+			cmp.Comparer(func(a, b RepositoryOptions) bool { return a.Equals(b) })))
 	}
 }
 
 func TestAddAndRemoveRepository(t *testing.T) {
-	// test cleanup
-	defer os.Remove("testdata/apt2/sources.list.d/managed.list")      //nolint:errcheck
-	defer os.Remove("testdata/apt2/sources.list.d/managed.list.save") //nolint:errcheck
-	defer os.Remove("testdata/apt2/sources.list.d/managed.list.new")  //nolint:errcheck
+	fsys := newMemFSFromDir(t, "testdata/apt2", "etc/apt")
+	withFS := WithFS(fsys)
 
 	repo1 := &Repository{
 		Enabled:      true,
@@ -67,59 +69,239 @@ func TestAddAndRemoveRepository(t *testing.T) {
 		Components:   "main",
 		Comment:      "",
 	}
-	err := AddRepository(repo1, "testdata/apt2")
+	err := AddRepository(repo1, "etc/apt", withFS)
 	require.NoError(t, err, "Adding repository")
-	err = AddRepository(repo2, "testdata/apt2")
+	err = AddRepository(repo2, "etc/apt", withFS)
 	require.NoError(t, err, "Adding repository")
 
 	// check that we have repo1 and repo2 added
-	repos, err := ParseAPTConfigFolder("testdata/apt2")
+	repos, err := ParseAPTConfigFolder("etc/apt", withFS)
 	require.NoError(t, err, "running List command")
 	require.True(t, repos.Contains(repo1), "Configuration contains: %#v", repo1)
 	require.True(t, repos.Contains(repo2), "Configuration contains: %#v", repo2)
 
-	err = AddRepository(repo2, "testdata/apt2")
+	err = AddRepository(repo2, "etc/apt", withFS)
 	require.Error(t, err, "Adding repository again")
 
 	// no changes should have happened
-	repos, err = ParseAPTConfigFolder("testdata/apt2")
+	repos, err = ParseAPTConfigFolder("etc/apt", withFS)
 	require.NoError(t, err, "running List command")
 	require.True(t, repos.Contains(repo1), "Configuration contains: %#v", repo1)
 	require.True(t, repos.Contains(repo2), "Configuration contains: %#v", repo2)
 
-	err = RemoveRepository(repo2, "testdata/apt2")
+	err = RemoveRepository(repo2, "etc/apt", withFS)
 	require.NoError(t, err, "Removing repository")
 
 	// repo2 should be removed
-	repos, err = ParseAPTConfigFolder("testdata/apt2")
+	repos, err = ParseAPTConfigFolder("etc/apt", withFS)
 	require.NoError(t, err, "running List command")
 	require.True(t, repos.Contains(repo1), "Configuration contains: %#v", repo1)
 	require.False(t, repos.Contains(repo2), "Configuration contains: %#v", repo2)
 
-	err = RemoveRepository(repo2, "testdata/apt2")
+	err = RemoveRepository(repo2, "etc/apt", withFS)
 	require.Error(t, err, "Removing repository again")
 
 	// no changes should have happened
-	repos, err = ParseAPTConfigFolder("testdata/apt2")
+	repos, err = ParseAPTConfigFolder("etc/apt", withFS)
 	require.NoError(t, err, "running List command")
 	require.True(t, repos.Contains(repo1), "Configuration contains: %#v", repo1)
 	require.False(t, repos.Contains(repo2), "Configuration contains: %#v", repo2)
 
-	err = EditRepository(repo1, repo2, "testdata/apt2")
+	err = EditRepository(repo1, repo2, "etc/apt", withFS)
 	require.NoError(t, err, "editing repository %#V -> %#V", repo1, repo2)
 
 	// repo2 should be changed to repo1
-	repos, err = ParseAPTConfigFolder("testdata/apt2")
+	repos, err = ParseAPTConfigFolder("etc/apt", withFS)
 	require.NoError(t, err, "running List command")
 	require.False(t, repos.Contains(repo1), "Configuration contains: %#v", repo1)
 	require.True(t, repos.Contains(repo2), "Configuration contains: %#v", repo2)
 
-	err = EditRepository(repo1, repo2, "testdata/apt2")
+	err = EditRepository(repo1, repo2, "etc/apt", withFS)
 	require.Error(t, err, "editing again repository %#v -> %#v", repo1, repo2)
 
 	// no changes should have happened
-	repos, err = ParseAPTConfigFolder("testdata/apt2")
+	repos, err = ParseAPTConfigFolder("etc/apt", withFS)
 	require.NoError(t, err, "running List command")
 	require.False(t, repos.Contains(repo1), "Configuration contains: %#v", repo1)
 	require.True(t, repos.Contains(repo2), "Configuration contains: %#v", repo2)
 }
+
+func TestRepositoryPosition(t *testing.T) {
+	repos, err := ParseAPTConfigFolder("testdata/apt")
+	require.NoError(t, err, "running List command")
+
+	require.Equal(t, "testdata/apt/sources.list", repos[0].File)
+	require.Equal(t, 5, repos[0].Line)
+	require.Equal(t, 0, repos[0].Stanza)
+	require.Equal(t, "deb http://it.archive.ubuntu.com/ubuntu/ zesty main restricted", repos[0].Raw)
+
+	security := repos.Find(&Repository{
+		SourceRepo:   true,
+		Options:      MustParseRepositoryOptions("signed-by=/usr/share/keyrings/ubuntu-archive-keyring.gpg"),
+		URI:          "http://security.ubuntu.com/ubuntu/",
+		Distribution: "noble-security",
+		Components:   "main restricted universe multiverse",
+	})
+	require.NotNil(t, security)
+	require.Equal(t, "testdata/apt/sources.list.d/ubuntu.sources", security.File)
+	require.Equal(t, 11, security.Line)
+	require.Equal(t, 2, security.Stanza)
+	require.Equal(t, `## Ubuntu security updates. Aside from URIs and Suites,
+## this should mirror your choices in the previous stanza.
+Types: deb deb-src
+URIs: http://security.ubuntu.com/ubuntu/
+Suites: noble-security
+Components: main restricted universe multiverse
+Signed-By: /usr/share/keyrings/ubuntu-archive-keyring.gpg
+Enabled: no`, security.Raw)
+}
+
+func TestRemoveRepositoryAtPosition(t *testing.T) {
+	folder := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(folder, "sources.list.d"), 0755))
+	sourcesList := filepath.Join(folder, "sources.list")
+	require.NoError(t, os.WriteFile(sourcesList, []byte(
+		"deb http://example.com/debian stable main\n"+
+			"deb http://example.com/debian testing main\n"+
+			"deb http://example.com/debian stable main\n"), 0644))
+
+	repos, err := ParseAPTConfigFolder(folder)
+	require.NoError(t, err)
+	require.Len(t, repos, 3)
+
+	// Only the entry at the given position is removed
+	require.NoError(t, RemoveRepository(repos[2], folder))
+	data, err := os.ReadFile(sourcesList)
+	require.NoError(t, err)
+	require.Equal(t, "deb http://example.com/debian stable main\n"+
+		"deb http://example.com/debian testing main\n", string(data))
+
+	// The position is now stale
+	require.Error(t, RemoveRepository(repos[2], folder))
+	repos[0].Line = 2
+	require.Error(t, EditRepository(repos[0], repos[1], folder))
+
+	// Without position the first matching entry is changed
+	stable := &Repository{Enabled: true, URI: "http://example.com/debian", Distribution: "stable", Components: "main"}
+	unstable := &Repository{Enabled: true, URI: "http://example.com/debian", Distribution: "unstable", Components: "main"}
+	require.NoError(t, EditRepository(stable, unstable, folder))
+	data, err = os.ReadFile(sourcesList)
+	require.NoError(t, err)
+	require.Equal(t, "deb http://example.com/debian unstable main\n"+
+		"deb http://example.com/debian testing main\n", string(data))
+}
+
+func TestFlatRepository(t *testing.T) {
+	flat := &Repository{Enabled: true, URI: "https://example.com/repo", Distribution: "./"}
+	require.True(t, flat.IsFlat())
+	require.Equal(t, "deb https://example.com/repo ./", flat.APTConfigLine())
+	require.True(t, flat.Equals(&Repository{URI: "https://example.com/", Distribution: "repo/"}))
+	require.False(t, flat.Equals(&Repository{URI: "https://example.com/repo", Distribution: "stable", Components: "main"}))
+	require.False(t, flat.Equals(&Repository{URI: "https://example.com/other", Distribution: "./"}))
+
+	folder := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(folder, "sources.list.d"), 0755))
+	sourcesList := filepath.Join(folder, "sources.list")
+	require.NoError(t, os.WriteFile(sourcesList, []byte("deb file:/srv/debs /\n"), 0644))
+
+	require.NoError(t, AddRepository(flat, folder))
+	repos, err := ParseAPTConfigFolder(folder)
+	require.NoError(t, err)
+	require.Len(t, repos, 2)
+	require.Equal(t, "file:/srv/debs", repos[0].URI)
+	require.Equal(t, "/", repos[0].Distribution)
+	require.True(t, repos[0].IsFlat())
+	require.True(t, repos.Contains(flat))
+
+	// Convert the flat repository into a regular one
+	regular := &Repository{Enabled: true, URI: "file:/srv/debs", Distribution: "stable", Components: "main"}
+	require.NoError(t, EditRepository(repos[0], regular, folder))
+	data, err := os.ReadFile(sourcesList)
+	require.NoError(t, err)
+	require.Equal(t, "deb file:/srv/debs stable main\n", string(data))
+
+	_, diags, err := ParseAPTConfigFolderWithDiagnostics(folder, ParseStrict)
+	require.NoError(t, err)
+	require.Empty(t, diags)
+	require.NoError(t, os.WriteFile(sourcesList, []byte("deb file:/srv/debs / main\n"), 0644))
+	_, _, err = ParseAPTConfigFolderWithDiagnostics(folder, ParseStrict)
+	require.Error(t, err)
+}
+
+func TestEnableDisableRepository(t *testing.T) {
+	fsys := NewMemFS(map[string]string{
+		"etc/apt/sources.list": "deb http://example.com/debian stable main # stable release\n" +
+			"#deb [arch=amd64] http://example.com/debian testing main  # testing release\n",
+	})
+	require.NoError(t, fsys.MkdirAll("etc/apt/sources.list.d", 0755))
+	repos, err := ParseAPTConfigFolder("etc/apt", WithFS(fsys))
+	require.NoError(t, err)
+	require.Len(t, repos, 2)
+
+	require.NoError(t, DisableRepository(repos[0], "etc/apt", WithFS(fsys)))
+	require.NoError(t, EnableRepository(repos[1], "etc/apt", WithFS(fsys)))
+	require.Equal(t, "# deb http://example.com/debian stable main # stable release\n"+
+		"deb [arch=amd64] http://example.com/debian testing main  # testing release\n", readFSFile(t, fsys, "etc/apt/sources.list"))
+
+	// Nothing changes if the repository is already in the requested state
+	require.NoError(t, DisableRepository(repos[0], "etc/apt", WithFS(fsys)))
+	require.NoError(t, EnableRepository(repos[0], "etc/apt", WithFS(fsys)))
+	require.Equal(t, "deb http://example.com/debian stable main # stable release\n"+
+		"deb [arch=amd64] http://example.com/debian testing main  # testing release\n", readFSFile(t, fsys, "etc/apt/sources.list"))
+
+	missing := &Repository{URI: "http://example.com/debian", Distribution: "unstable", Components: "main"}
+	require.EqualError(t, EnableRepository(missing, "etc/apt", WithFS(fsys)), "repository doesn't exist")
+}
+
+func TestRepositorySemanticEquals(t *testing.T) {
+	repo := &Repository{
+		URI:          "http://deb.debian.org/debian",
+		Distribution: "bookworm",
+		Components:   "main contrib",
+		Options:      MustParseRepositoryOptions("arch=amd64,arm64 signed-by=/etc/apt/keyrings/debian.gpg"),
+	}
+	same := &Repository{
+		URI:          "HTTP://Deb.Debian.org:80/debian/",
+		Distribution: "bookworm",
+		Components:   "contrib  main",
+		Options:      MustParseRepositoryOptions("signed-by=/etc/apt/keyrings/debian.gpg arch=arm64,amd64"),
+	}
+	require.True(t, repo.Equals(same))
+	require.True(t, same.Equals(repo))
+
+	for _, uri := range []string{"https://deb.debian.org/debian", "http://deb.debian.org:8080/debian", "http://deb.debian.org/debian/updates"} {
+		other := *repo
+		other.URI = uri
+		require.False(t, repo.Equals(&other), uri)
+	}
+	subset := *repo
+	subset.Components = "main"
+	require.False(t, repo.Equals(&subset))
+	require.Equal(t, "cdrom:[Debian GNU/Linux 12]", canonicalURI("cdrom:[Debian GNU/Linux 12]/"))
+	require.Equal(t, "http://[::1]:8080/debian", canonicalURI("http://[::1]:8080//debian/"))
+}
+
+func TestRepositoryOverlaps(t *testing.T) {
+	repo := &Repository{URI: "http://deb.debian.org/debian", Distribution: "bookworm", Components: "main contrib"}
+	overlapping := &Repository{URI: "http://deb.debian.org/debian/", Distribution: "bookworm", Components: "main", Options: MustParseRepositoryOptions("arch=amd64")}
+	require.True(t, repo.Overlaps(overlapping))
+	require.True(t, overlapping.Overlaps(repo))
+	require.False(t, repo.Overlaps(&Repository{URI: "http://deb.debian.org/debian", Distribution: "bookworm", Components: "non-free"}))
+	require.False(t, repo.Overlaps(&Repository{URI: "http://deb.debian.org/debian", Distribution: "trixie", Components: "main"}))
+	require.False(t, repo.Overlaps(&Repository{SourceRepo: true, URI: "http://deb.debian.org/debian", Distribution: "bookworm", Components: "main"}))
+	require.True(t, (&Repository{URI: "http://host/repo", Distribution: "./"}).Overlaps(&Repository{URI: "http://host/", Distribution: "repo/"}))
+
+	fsys := newTransactionTestFS()
+	err := AddRepository(&Repository{Enabled: true, URI: "http://example.com/debian/", Distribution: "stable", Components: "main"}, "etc/apt", WithFS(fsys))
+	require.EqualError(t, err, "the repository is already configured")
+
+	extended := &Repository{Enabled: true, URI: "http://example.com/debian", Distribution: "stable", Components: "main contrib"}
+	err = AddRepository(extended, "etc/apt", WithFS(fsys), WithOverlapCheck())
+	require.EqualError(t, err, "the repository overlaps with the one at etc/apt/sources.list:1")
+	tx := NewTransaction("etc/apt", WithFS(fsys), WithOverlapCheck())
+	tx.AddRepository(&Repository{Enabled: true, URI: "http://new.example.com/debian", Distribution: "stable", Components: "main"})
+	tx.AddRepository(&Repository{Enabled: true, URI: "http://new.example.com/debian", Distribution: "stable", Components: "main contrib"})
+	require.EqualError(t, tx.Commit(), "the repository overlaps with another added repository")
+
+	require.NoError(t, AddRepository(extended, "etc/apt", WithFS(fsys)))
+}
@@ -0,0 +1,233 @@
+//
+//  This file is part of go-apt-client library
+//
+//  Copyright (C) 2017  Arduino AG (http://www.arduino.cc/)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+//
+
+package apt
+
+import (
+	"cmp"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Filter returns the repositories of the RepositoryList for which keep
+// returns true
+func (r RepositoryList) Filter(keep func(repo *Repository) bool) RepositoryList {
+	res := RepositoryList{}
+	for _, repo := range r {
+		if keep(repo) {
+			res = append(res, repo)
+		}
+	}
+	return res
+}
+
+// Enabled returns the enabled repositories
+func (r RepositoryList) Enabled() RepositoryList {
+	return r.Filter(func(repo *Repository) bool { return repo.Enabled })
+}
+
+// Binary returns the repositories of binary packages ("deb")
+func (r RepositoryList) Binary() RepositoryList {
+	return r.Filter(func(repo *Repository) bool { return !repo.SourceRepo })
+}
+
+// Source returns the repositories of source packages ("deb-src")
+func (r RepositoryList) Source() RepositoryList {
+	return r.Filter(func(repo *Repository) bool { return repo.SourceRepo })
+}
+
+// ByHost returns the repositories whose URI points to the given host,
+// the comparison is case-insensitive
+func (r RepositoryList) ByHost(host string) RepositoryList {
+	return r.Filter(func(repo *Repository) bool {
+		u, err := url.Parse(repo.URI)
+		return err == nil && strings.EqualFold(u.Hostname(), host)
+	})
+}
+
+// BySuite returns the repositories of the given distribution (suite)
+func (r RepositoryList) BySuite(suite string) RepositoryList {
+	return r.Filter(func(repo *Repository) bool { return repo.Distribution == suite })
+}
+
+// Dedup returns the RepositoryList without the duplicated repositories
+// (see Repository.Equals), only the first of the equivalent repositories
+// is kept
+func (r RepositoryList) Dedup() RepositoryList {
+	res := RepositoryList{}
+	for _, repo := range r {
+		if !res.Contains(repo) {
+			res = append(res, repo)
+		}
+	}
+	return res
+}
+
+// Sorted returns the repositories sorted by URI, distribution, type
+// ("deb" before "deb-src") and components
+func (r RepositoryList) Sorted() RepositoryList {
+	res := slices.Clone(r)
+	slices.SortStableFunc(res, func(a, b *Repository) int {
+		return cmp.Or(
+			cmp.Compare(canonicalURI(a.URI), canonicalURI(b.URI)),
+			cmp.Compare(a.Distribution, b.Distribution),
+			cmp.Compare(a.sourceType(), b.sourceType()),
+			slices.Compare(a.components(), b.components()))
+	})
+	return res
+}
+
+// Union returns the repositories of the RepositoryList followed by the
+// ones of other that are not already contained
+func (r RepositoryList) Union(other RepositoryList) RepositoryList {
+	res := slices.Clone(r)
+	for _, repo := range other {
+		if !res.Contains(repo) {
+			res = append(res, repo)
+		}
+	}
+	return res
+}
+
+// Intersect returns the repositories of the RepositoryList that are
+// contained in other too
+func (r RepositoryList) Intersect(other RepositoryList) RepositoryList {
+	return r.Filter(other.Contains)
+}
+
+// Subtract returns the repositories of the RepositoryList that are not
+// contained in other
+func (r RepositoryList) Subtract(other RepositoryList) RepositoryList {
+	return r.Filter(func(repo *Repository) bool { return !other.Contains(repo) })
+}
+
+// RepositoryListDiff is the difference between two RepositoryList
+type RepositoryListDiff struct {
+	Added   RepositoryList
+	Removed RepositoryList
+	Changed []*RepositoryChange
+}
+
+// RepositoryChange is a repository present in both the lists compared by
+// RepositoryList.Diff, with different settings
+type RepositoryChange struct {
+	Old    *Repository
+	New    *Repository
+	Fields []*FieldChange
+}
+
+// FieldChange is the change of a field of a Repository
+type FieldChange struct {
+	// Field is the name of the field, one of "Enabled", "URI",
+	// "Distribution", "Components", "Options" or "Comment". Mirror
+	// switches report also the "Credentials" moved to the auth files.
+	Field string
+	Old   string
+	New   string
+}
+
+// IsEmpty returns true if the lists are equivalent
+func (d *RepositoryListDiff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// identity returns the key that identifies a repository when comparing
+// two lists: type, URI and distribution
+func (r *Repository) identity() string {
+	if r.IsFlat() {
+		return r.sourceType() + " " + r.flatLocation()
+	}
+	return r.sourceType() + " " + canonicalURI(r.URI) + " " + r.Distribution
+}
+
+// changedFields returns the differences between the repositories
+func (r *Repository) changedFields(repo *Repository) []*FieldChange {
+	res := []*FieldChange{}
+	if r.Enabled != repo.Enabled {
+		res = append(res, &FieldChange{"Enabled", strconv.FormatBool(r.Enabled), strconv.FormatBool(repo.Enabled)})
+	}
+	// Flat repositories may point to the same folder in different ways
+	if !r.IsFlat() || !repo.IsFlat() || r.flatLocation() != repo.flatLocation() {
+		if canonicalURI(r.URI) != canonicalURI(repo.URI) {
+			res = append(res, &FieldChange{"URI", r.URI, repo.URI})
+		}
+		if r.Distribution != repo.Distribution {
+			res = append(res, &FieldChange{"Distribution", r.Distribution, repo.Distribution})
+		}
+	}
+	if !slices.Equal(r.components(), repo.components()) {
+		res = append(res, &FieldChange{"Components", r.Components, repo.Components})
+	}
+	if !r.Options.Equals(repo.Options) {
+		res = append(res, &FieldChange{"Options", r.Options.String(), repo.Options.String()})
+	}
+	if strings.TrimSpace(r.Comment) != strings.TrimSpace(repo.Comment) {
+		res = append(res, &FieldChange{"Comment", r.Comment, repo.Comment})
+	}
+	return res
+}
+
+// Diff compares the RepositoryList with newList. The repositories are
+// matched by type, URI and distribution, preferring the ones with the same
+// settings: the ones only in newList are added, the ones only in the
+// RepositoryList are removed, and the ones in both lists with different
+// settings are changed.
+func (r RepositoryList) Diff(newList RepositoryList) *RepositoryListDiff {
+	res := &RepositoryListDiff{Added: RepositoryList{}, Removed: RepositoryList{}, Changed: []*RepositoryChange{}}
+	matched := make([]bool, len(newList))
+	pairs := make([]int, len(r))
+	for j := range pairs {
+		pairs[j] = -1
+	}
+	// match pairs the old repositories with the first unmatched new one
+	// that satisfies the condition
+	match := func(cond func(old, repo *Repository) bool) {
+		for j, old := range r {
+			if pairs[j] != -1 {
+				continue
+			}
+			for i, repo := range newList {
+				if !matched[i] && repo.identity() == old.identity() && cond(old, repo) {
+					matched[i] = true
+					pairs[j] = i
+					break
+				}
+			}
+		}
+	}
+	match(func(old, repo *Repository) bool { return len(old.changedFields(repo)) == 0 })
+	match(func(old, repo *Repository) bool { return true })
+
+	for j, old := range r {
+		if pairs[j] == -1 {
+			res.Removed = append(res.Removed, old)
+			continue
+		}
+		if fields := old.changedFields(newList[pairs[j]]); len(fields) > 0 {
+			res.Changed = append(res.Changed, &RepositoryChange{Old: old, New: newList[pairs[j]], Fields: fields})
+		}
+	}
+	for i, repo := range newList {
+		if !matched[i] {
+			res.Added = append(res.Added, repo)
+		}
+	}
+	return res
+}
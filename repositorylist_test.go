@@ -0,0 +1,99 @@
+//
+//  This file is part of go-apt-client library
+//
+//  Copyright (C) 2017  Arduino AG (http://www.arduino.cc/)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+//
+
+package apt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRepositoryList() RepositoryList {
+	return RepositoryList{
+		{Enabled: true, URI: "http://deb.debian.org/debian", Distribution: "bookworm", Components: "main"},
+		{Enabled: true, SourceRepo: true, URI: "http://deb.debian.org/debian", Distribution: "bookworm", Components: "main"},
+		{Enabled: false, URI: "http://security.debian.org/debian-security", Distribution: "bookworm-security", Components: "main"},
+		{Enabled: true, URI: "https://Example.com/repo", Distribution: "./"},
+		{Enabled: true, URI: "http://deb.debian.org/debian/", Distribution: "bookworm", Components: "main"},
+	}
+}
+
+func TestRepositoryListFilters(t *testing.T) {
+	repos := newTestRepositoryList()
+	require.Equal(t, RepositoryList{repos[0], repos[1], repos[3], repos[4]}, repos.Enabled())
+	require.Equal(t, RepositoryList{repos[1]}, repos.Source())
+	require.Equal(t, RepositoryList{repos[0], repos[2], repos[3], repos[4]}, repos.Binary())
+	require.Equal(t, RepositoryList{repos[0], repos[1], repos[4]}, repos.ByHost("DEB.debian.org"))
+	require.Equal(t, RepositoryList{repos[3]}, repos.ByHost("example.com"))
+	require.Equal(t, RepositoryList{repos[2]}, repos.BySuite("bookworm-security"))
+	require.Equal(t, RepositoryList{repos[1]}, repos.Enabled().Source())
+	require.Equal(t, RepositoryList{repos[0], repos[1], repos[2], repos[3]}, repos.Dedup())
+	require.Equal(t, RepositoryList{repos[0], repos[4], repos[1], repos[2], repos[3]}, repos.Sorted())
+	require.Len(t, repos, 5)
+}
+
+func TestRepositoryListSetOperations(t *testing.T) {
+	repos := newTestRepositoryList()
+	other := RepositoryList{
+		{Enabled: true, URI: "http://deb.debian.org/debian", Distribution: "bookworm", Components: "main"},
+		{Enabled: true, URI: "http://deb.debian.org/debian", Distribution: "bookworm-updates", Components: "main"},
+	}
+	require.Equal(t, RepositoryList{repos[0], repos[1], repos[2], repos[3], repos[4], other[1]}, repos.Union(other))
+	require.Equal(t, RepositoryList{repos[0], repos[4]}, repos.Intersect(other))
+	require.Equal(t, RepositoryList{repos[1], repos[2], repos[3]}, repos.Subtract(other))
+	require.Equal(t, RepositoryList{other[1]}, other.Subtract(repos))
+}
+
+func TestRepositoryListDiff(t *testing.T) {
+	oldList := newTestRepositoryList()[:4]
+	newList := RepositoryList{
+		{Enabled: true, URI: "http://deb.debian.org/debian/", Distribution: "bookworm", Components: "main contrib", Options: MustParseRepositoryOptions("arch=amd64")},
+		{Enabled: true, URI: "http://security.debian.org/debian-security", Distribution: "bookworm-security", Components: "main", Comment: "enabled"},
+		{Enabled: true, URI: "https://example.com/", Distribution: "repo/"},
+		{Enabled: true, URI: "http://deb.debian.org/debian", Distribution: "bookworm-updates", Components: "main"},
+	}
+	diff := oldList.Diff(newList)
+	require.Equal(t, RepositoryList{newList[3]}, diff.Added)
+	require.Equal(t, RepositoryList{oldList[1]}, diff.Removed)
+	require.Equal(t, []*RepositoryChange{
+		{Old: oldList[0], New: newList[0], Fields: []*FieldChange{
+			{Field: "Components", Old: "main", New: "main contrib"},
+			{Field: "Options", Old: "", New: "arch=amd64"},
+		}},
+		{Old: oldList[2], New: newList[1], Fields: []*FieldChange{
+			{Field: "Enabled", Old: "false", New: "true"},
+			{Field: "Comment", Old: "", New: "enabled"},
+		}},
+	}, diff.Changed)
+	require.False(t, diff.IsEmpty())
+	require.True(t, oldList.Diff(oldList).IsEmpty())
+
+	// The same suite split on several lines, like in Ubuntu
+	oldList = RepositoryList{
+		{Enabled: true, URI: "http://archive.ubuntu.com/ubuntu", Distribution: "noble", Components: "main restricted"},
+		{Enabled: true, URI: "http://archive.ubuntu.com/ubuntu", Distribution: "noble", Components: "universe"},
+	}
+	newList = RepositoryList{
+		{Enabled: true, URI: "http://archive.ubuntu.com/ubuntu", Distribution: "noble", Components: "universe"},
+	}
+	diff = oldList.Diff(newList)
+	require.Empty(t, diff.Added)
+	require.Equal(t, RepositoryList{oldList[0]}, diff.Removed)
+	require.Empty(t, diff.Changed)
+}
@@ -0,0 +1,374 @@
+//
+//  This file is part of go-apt-client library
+//
+//  Copyright (C) 2017  Arduino AG (http://www.arduino.cc/)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+//
+
+package apt
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// sourceFile is the concrete syntax model of an APT source file: it
+// keeps comments, blank lines and the original layout of every entry so
+// that editing a repository changes only the text of that entry.
+type sourceFile interface {
+	// repositories returns the repositories defined in the file
+	repositories() RepositoryList
+	// add appends the repository at the end of the file
+	add(repo *Repository)
+	// remove deletes the repository from the entry at its position
+	// (Line and Stanza), it returns false if the entry doesn't define
+	// the repository
+	remove(repo *Repository) bool
+	// replace changes the repository old into newRepo in the entry at
+	// the position of old, it returns false if the entry doesn't define
+	// the repository old
+	replace(old, newRepo *Repository) bool
+	// bytes returns the content of the file
+	bytes() []byte
+	// diagnostics returns the malformed entries of the file
+	diagnostics() []*Diagnostic
+}
+
+// parseSourceFile parses the content of the APT source file at path,
+// the format is selected based on the file extension.
+func parseSourceFile(path string, data []byte) sourceFile {
+	if isDeb822File(path) {
+		return newSourcesFile(data)
+	}
+	return parseSourceListFile(data)
+}
+
+// sourceListFile is a file in the one-line-style format (like
+// "sources.list" or the "*.list" files in "sources.list.d").
+type sourceListFile struct {
+	lines []*sourceListLine
+}
+
+// sourceListLine is a line of a one-line-style file. If the line is an
+// entry, the text is split into tokens that retain the original spacing,
+// otherwise the line is kept verbatim.
+type sourceListLine struct {
+	text    string
+	eol     string
+	repo    *Repository
+	problem string // the reason why the entry is malformed
+
+	prefix   string       // leading whitespace and "#" marker of disabled entries
+	fields   []*lineToken // type, options, URI, distribution and components
+	comment  *lineToken   // trailing comment, including the "#"
+	trailing string       // trailing whitespace
+}
+
+// lineToken is a token of a line with the whitespace that precedes it
+type lineToken struct {
+	space string
+	text  string
+}
+
+func parseSourceListFile(data []byte) *sourceListFile {
+	res := &sourceListFile{}
+	for _, line := range splitLines(string(data)) {
+		text := trimEOL(line)
+		res.lines = append(res.lines, parseSourceListLine(text, line[len(text):]))
+	}
+	return res
+}
+
+func parseSourceListLine(text, eol string) *sourceListLine {
+	res := &sourceListLine{text: text, eol: eol}
+	if !res.tokenize() {
+		return res
+	}
+	res.repo, res.problem = res.parseRepository()
+	return res
+}
+
+// tokenize splits the text of the line in tokens, it returns false if
+// the line is not an entry (a blank line or a comment). Like apt, any
+// sequence of spaces and tabs separates the tokens and a "#" starts a
+// comment. Commented lines are considered disabled entries if the text
+// after the "#" starts with a source type.
+func (l *sourceListLine) tokenize() bool {
+	text := l.text
+	i := skipSpaces(text, 0)
+	if i < len(text) && text[i] == '#' {
+		i = skipSpaces(text, i+1)
+		if !hasSourceType(text[i:]) {
+			return false
+		}
+	}
+	if i == len(text) {
+		return false
+	}
+	l.prefix = text[:i]
+	for i < len(text) {
+		start := i
+		i = skipSpaces(text, i)
+		if i == len(text) {
+			l.trailing = text[start:]
+			break
+		}
+		token := &lineToken{space: text[start:i]}
+		if text[i] == '#' {
+			end := len(strings.TrimRight(text, " \t"))
+			token.text = text[i:end]
+			l.comment = token
+			l.trailing = text[end:]
+			break
+		}
+		end := i
+		if text[i] == '[' && len(l.fields) == 1 {
+			// The options may contain spaces, they end at the "]"
+			end = i + strings.IndexAny(text[i:]+"#", "]#")
+			if end < len(text) && text[end] == ']' {
+				end++
+			} else {
+				end = i + len(strings.TrimRight(text[i:end], " \t"))
+			}
+		} else {
+			for end < len(text) && text[end] != ' ' && text[end] != '\t' && text[end] != '#' {
+				end++
+			}
+		}
+		token.text = text[i:end]
+		l.fields = append(l.fields, token)
+		i = end
+	}
+	return true
+}
+
+// hasSourceType returns true if the text starts with "deb" or "deb-src"
+func hasSourceType(text string) bool {
+	for _, t := range []string{"deb-src", "deb"} {
+		if rest, ok := strings.CutPrefix(text, t); ok {
+			return rest == "" || rest[0] == ' ' || rest[0] == '\t' || rest[0] == '['
+		}
+	}
+	return false
+}
+
+// disabled returns true if the entry is commented out
+func (l *sourceListLine) disabled() bool {
+	return strings.Contains(l.prefix, "#")
+}
+
+// parseRepository returns the repository defined by the tokens of the
+// line, or the reason why the entry is malformed.
+func (l *sourceListLine) parseRepository() (*Repository, string) {
+	fields := []string{}
+	for _, f := range l.fields {
+		fields = append(fields, f.text)
+	}
+	repo := &Repository{Enabled: !l.disabled()}
+	switch fields[0] {
+	case "deb":
+	case "deb-src":
+		repo.SourceRepo = true
+	default:
+		return nil, fmt.Sprintf("unknown source type '%s'", fields[0])
+	}
+	fields = fields[1:]
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "[") {
+		opts, ok := strings.CutSuffix(fields[0][1:], "]")
+		if !ok {
+			return nil, "missing ']' at the end of the options"
+		}
+		options, err := ParseRepositoryOptions(opts)
+		if err != nil {
+			return nil, fmt.Sprintf("invalid options: %s", err)
+		}
+		repo.Options = options
+		fields = fields[1:]
+	}
+	switch len(fields) {
+	case 0:
+		return nil, "missing URI"
+	case 1:
+		return nil, "missing distribution"
+	}
+	repo.URI = fields[0]
+	repo.Distribution = fields[1]
+	repo.Components = strings.Join(fields[2:], " ")
+	if repo.IsFlat() && repo.Components != "" {
+		return nil, "components are not allowed in flat repositories"
+	}
+	if !repo.IsFlat() && repo.Components == "" {
+		return nil, "missing components"
+	}
+	if l.comment != nil {
+		repo.Comment = strings.TrimLeft(l.comment.text[1:], " \t")
+	}
+	return repo, ""
+}
+
+func skipSpaces(text string, i int) int {
+	for i < len(text) && (text[i] == ' ' || text[i] == '\t') {
+		i++
+	}
+	return i
+}
+
+// setRepository changes the entry so that it defines the repository repo.
+// The tokens that doesn't change retain their original text and spacing.
+func (l *sourceListLine) setRepository(repo *Repository) {
+	if l.repo == nil {
+		line := parseSourceListLine(repo.APTConfigLine(), l.eol)
+		*l = *line
+		return
+	}
+	old := l.repo
+
+	if old.Enabled != repo.Enabled {
+		indent := l.prefix[:skipSpaces(l.prefix, 0)]
+		if repo.Enabled {
+			l.prefix = indent
+		} else {
+			l.prefix = indent + "# "
+		}
+	}
+
+	oldFields := l.fields
+	token := func(i int, text string) *lineToken {
+		if i < len(oldFields) {
+			return &lineToken{space: oldFields[i].space, text: text}
+		}
+		return &lineToken{space: " ", text: text}
+	}
+	fields := []*lineToken{{space: oldFields[0].space, text: repo.sourceType()}}
+	idx := 1
+	if strings.HasPrefix(oldFields[1].text, "[") {
+		if old.Options.Equals(repo.Options) {
+			fields = append(fields, oldFields[1])
+		} else if !repo.Options.IsEmpty() {
+			fields = append(fields, &lineToken{space: oldFields[1].space, text: "[" + repo.Options.String() + "]"})
+		}
+		idx = 2
+	} else if !repo.Options.IsEmpty() {
+		fields = append(fields, &lineToken{space: " ", text: "[" + repo.Options.String() + "]"})
+	}
+	fields = append(fields, token(idx, repo.URI), token(idx+1, repo.Distribution))
+	if old.Components == repo.Components {
+		fields = append(fields, oldFields[idx+2:]...)
+	} else {
+		for i, c := range strings.Fields(repo.Components) {
+			fields = append(fields, token(idx+2+i, c))
+		}
+	}
+	l.fields = fields
+
+	if old.Comment != repo.Comment {
+		switch {
+		case strings.TrimSpace(repo.Comment) == "":
+			l.comment = nil
+		case l.comment != nil:
+			l.comment = &lineToken{space: l.comment.space, text: "# " + repo.Comment}
+		default:
+			l.comment = &lineToken{space: " ", text: "# " + repo.Comment}
+		}
+	}
+
+	var text strings.Builder
+	text.WriteString(l.prefix)
+	for _, f := range l.fields {
+		text.WriteString(f.space + f.text)
+	}
+	if l.comment != nil {
+		text.WriteString(l.comment.space + l.comment.text)
+	}
+	text.WriteString(l.trailing)
+	l.text = text.String()
+	newRepo := *repo
+	l.repo = &newRepo
+}
+
+func (f *sourceListFile) repositories() RepositoryList {
+	res := RepositoryList{}
+	for i, line := range f.lines {
+		if line.repo != nil {
+			repo := *line.repo
+			repo.Line = i + 1
+			repo.Stanza = 0
+			repo.Raw = redactText(line.text)
+			res = append(res, &repo)
+		}
+	}
+	return res
+}
+
+// entry returns the line at the position of the repository, if it
+// defines the repository
+func (f *sourceListFile) entry(repo *Repository) *sourceListLine {
+	if repo.Line < 1 || repo.Line > len(f.lines) {
+		return nil
+	}
+	line := f.lines[repo.Line-1]
+	if line.repo == nil || !line.repo.Equals(repo) {
+		return nil
+	}
+	return line
+}
+
+func (f *sourceListFile) add(repo *Repository) {
+	if n := len(f.lines); n > 0 && f.lines[n-1].eol == "" {
+		f.lines[n-1].eol = "\n"
+	}
+	f.lines = append(f.lines, parseSourceListLine(repo.APTConfigLine(), "\n"))
+}
+
+func (f *sourceListFile) remove(repo *Repository) bool {
+	line := f.entry(repo)
+	if line == nil {
+		return false
+	}
+	f.lines = slices.DeleteFunc(f.lines, func(l *sourceListLine) bool { return l == line })
+	return true
+}
+
+func (f *sourceListFile) replace(old, newRepo *Repository) bool {
+	line := f.entry(old)
+	if line == nil {
+		return false
+	}
+	line.setRepository(newRepo)
+	return true
+}
+
+func (f *sourceListFile) diagnostics() []*Diagnostic {
+	res := []*Diagnostic{}
+	for i, line := range f.lines {
+		if line.problem == "" {
+			continue
+		}
+		severity := SeverityError
+		if line.disabled() {
+			severity = SeverityWarning
+		}
+		res = append(res, &Diagnostic{Line: i + 1, Severity: severity, Reason: line.problem, Text: redactText(line.text)})
+	}
+	return res
+}
+
+func (f *sourceListFile) bytes() []byte {
+	var res strings.Builder
+	for _, line := range f.lines {
+		res.WriteString(line.text + line.eol)
+	}
+	return []byte(res.String())
+}
@@ -0,0 +1,121 @@
+//
+//  This file is part of go-apt-client library
+//
+//  Copyright (C) 2017  Arduino AG (http://www.arduino.cc/)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+//
+
+package apt
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSourceFileRoundTrip(t *testing.T) {
+	files, err := filepath.Glob("testdata/apt/sources.list.d/*")
+	require.NoError(t, err)
+	files = append(files, "testdata/apt/sources.list")
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, string(data), string(parseSourceFile(path, data).bytes()), "round trip of %s", path)
+	}
+}
+
+// setupSourcesListConfigFolder copies testdata/apt/sources.list into
+// a new config folder and returns the folder and the original content
+func setupSourcesListConfigFolder(t *testing.T) (string, string) {
+	folder := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(folder, "sources.list.d"), 0755))
+	data, err := os.ReadFile("testdata/apt/sources.list")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(folder, "sources.list"), data, 0644))
+	return folder, string(data)
+}
+
+func readSourcesList(t *testing.T, folder string) string {
+	data, err := os.ReadFile(filepath.Join(folder, "sources.list"))
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestLosslessEditRepository(t *testing.T) {
+	folder, original := setupSourcesListConfigFolder(t)
+
+	epson := &Repository{
+		Enabled:      false,
+		URI:          "http://download.ebz.epson.net/dsc/op/stable/debian/",
+		Distribution: "lsb3.2",
+		Components:   "main",
+		Comment:      "disabled on upgrade to trusty disabled on upgrade to utopic",
+	}
+	newEpson := *epson
+	newEpson.Enabled = true
+	newEpson.Components = "main non-free"
+	require.NoError(t, EditRepository(epson, &newEpson, folder))
+	expected := strings.Replace(original,
+		"# deb http://download.ebz.epson.net/dsc/op/stable/debian/ lsb3.2 main # disabled on upgrade to trusty disabled on upgrade to utopic\n",
+		"deb http://download.ebz.epson.net/dsc/op/stable/debian/ lsb3.2 main non-free # disabled on upgrade to trusty disabled on upgrade to utopic\n", 1)
+	require.Equal(t, expected, readSourcesList(t, folder))
+
+	security := &Repository{
+		Enabled:      true,
+		URI:          "http://security.ubuntu.com/ubuntu",
+		Distribution: "zesty-security",
+		Components:   "universe",
+	}
+	newSecurity := *security
+	newSecurity.Options = MustParseRepositoryOptions("arch=amd64")
+	newSecurity.Comment = "only amd64"
+	require.NoError(t, EditRepository(security, &newSecurity, folder))
+	expected = strings.Replace(expected,
+		"deb http://security.ubuntu.com/ubuntu zesty-security universe\n",
+		"deb [arch=amd64] http://security.ubuntu.com/ubuntu zesty-security universe # only amd64\n", 1)
+	require.Equal(t, expected, readSourcesList(t, folder))
+
+	require.NoError(t, RemoveRepository(&newSecurity, folder))
+	expected = strings.Replace(expected,
+		"deb [arch=amd64] http://security.ubuntu.com/ubuntu zesty-security universe # only amd64\n", "", 1)
+	require.Equal(t, expected, readSourcesList(t, folder))
+}
+
+func TestLosslessEditPreservesLayout(t *testing.T) {
+	data := "deb [arch=amd64] http://example.com/debian stable main   contrib #  my repo  \r\n" +
+		"# another comment\r\n" +
+		"deb http://example.com/debian testing main"
+	file := parseSourceFile("test.list", []byte(data))
+	repos := file.repositories()
+	require.Len(t, repos, 2)
+	require.Equal(t, "main contrib", repos[0].Components)
+
+	newRepo := *repos[0]
+	newRepo.Distribution = "oldstable"
+	require.True(t, file.replace(repos[0], &newRepo))
+	require.Equal(t, "deb [arch=amd64] http://example.com/debian oldstable main   contrib #  my repo  \r\n"+
+		"# another comment\r\n"+
+		"deb http://example.com/debian testing main", string(file.bytes()))
+
+	file.add(&Repository{Enabled: true, URI: "http://example.com/debian", Distribution: "unstable", Components: "main"})
+	require.Equal(t, "deb [arch=amd64] http://example.com/debian oldstable main   contrib #  my repo  \r\n"+
+		"# another comment\r\n"+
+		"deb http://example.com/debian testing main\n"+
+		"deb http://example.com/debian unstable main\n", string(file.bytes()))
+
+	require.False(t, file.remove(&Repository{URI: "http://example.com/debian", Distribution: "experimental", Components: "main"}))
+}
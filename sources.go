@@ -0,0 +1,500 @@
+//
+//  This file is part of go-apt-client library
+//
+//  Copyright (C) 2017  Arduino AG (http://www.arduino.cc/)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+//
+
+package apt
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// options returns the options of the stanza
+func (p *deb822Paragraph) options() (RepositoryOptions, error) {
+	res := RepositoryOptions{}
+	for _, item := range p.items {
+		if item.name == "" {
+			continue
+		}
+		key, op, ok := deb822FieldToOption(item.name)
+		if !ok {
+			continue
+		}
+		value := item.value()
+		values := splitOptionValues(value)
+		if key == "signed-by" && strings.Contains(value, "-----BEGIN") {
+			// Embedded key
+			values = []string{value}
+		}
+		if err := res.set(key, op, values); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// setOptions replaces the option fields of the stanza with the given
+// options. The fields that doesn't change are left untouched.
+func (p *deb822Paragraph) setOptions(options RepositoryOptions) {
+	if current, err := p.options(); err == nil && current.Equals(options) {
+		return
+	}
+	entries := options.entries()
+	fields := []string{}
+	for _, e := range entries {
+		fields = append(fields, optionToDeb822Field(e.key, e.op))
+	}
+
+	// Remove the options that are no longer needed
+	p.items = slices.DeleteFunc(p.items, func(item *deb822Item) bool {
+		if item.name == "" {
+			return false
+		}
+		if _, _, ok := deb822FieldToOption(item.name); !ok {
+			return false
+		}
+		return !slices.ContainsFunc(fields, func(f string) bool { return strings.EqualFold(f, item.name) })
+	})
+	for i, e := range entries {
+		if item := p.field(fields[i]); item != nil && sameSet(splitOptionValues(item.value()), e.values) {
+			continue
+		}
+		p.set(fields[i], strings.Join(e.values, " "))
+	}
+}
+
+// repositories returns all the repositories defined in the stanza, that
+// is every combination of the Types, URIs and Suites fields.
+func (p *deb822Paragraph) repositories() RepositoryList {
+	types := p.getList("Types")
+	uris := p.getList("URIs")
+	suites := p.getList("Suites")
+	enabled := parseDeb822Bool(p.get("Enabled"), true)
+	components := strings.Join(p.getList("Components"), " ")
+	options, err := p.options()
+	if err != nil {
+		return nil
+	}
+
+	res := RepositoryList{}
+	for _, t := range types {
+		if t != "deb" && t != "deb-src" {
+			continue
+		}
+		for _, uri := range uris {
+			for _, suite := range suites {
+				res = append(res, &Repository{
+					Enabled:      enabled,
+					SourceRepo:   t == "deb-src",
+					Options:      options,
+					URI:          uri,
+					Distribution: suite,
+					Components:   components,
+				})
+			}
+		}
+	}
+	return res
+}
+
+// diagnostics checks the stanza, that starts at the given line, and
+// returns the problems found
+func (p *deb822Paragraph) diagnostics(line int) []*Diagnostic {
+	severity := SeverityError
+	if !parseDeb822Bool(p.get("Enabled"), true) {
+		severity = SeverityWarning
+	}
+	res := []*Diagnostic{}
+	report := func(line int, text, reason string) {
+		res = append(res, &Diagnostic{Line: line, Severity: severity, Reason: reason, Text: redactText(text)})
+	}
+
+	itemLine := line
+	for _, item := range p.items {
+		if text := trimEOL(item.lines[0]); item.name != "" && !strings.Contains(text, ":") {
+			report(itemLine, text, "missing ':' after the field name")
+		}
+		itemLine += len(item.lines)
+	}
+	for _, name := range []string{"Types", "URIs", "Suites"} {
+		if len(p.getList(name)) == 0 {
+			report(line, p.text(), fmt.Sprintf("missing %s field", name))
+		}
+	}
+	hasComponents := len(p.getList("Components")) > 0
+	for _, suite := range p.getList("Suites") {
+		flat := strings.HasSuffix(suite, "/")
+		if flat && hasComponents {
+			report(line, p.text(), fmt.Sprintf("components are not allowed in flat repositories (suite '%s')", suite))
+		} else if !flat && !hasComponents {
+			report(line, p.text(), "missing Components field")
+			break
+		}
+	}
+	for _, t := range p.getList("Types") {
+		if t != "deb" && t != "deb-src" {
+			report(line, p.text(), fmt.Sprintf("unknown source type '%s'", t))
+		}
+	}
+	if _, err := p.options(); err != nil {
+		report(line, p.text(), fmt.Sprintf("invalid options: %s", err))
+	}
+	return res
+}
+
+// text returns the text of the stanza without the final line break
+func (p *deb822Paragraph) text() string {
+	raw := string((&deb822File{paragraphs: []*deb822Paragraph{p}}).Bytes())
+	return strings.TrimRight(raw, "\r\n")
+}
+
+// setRepository changes the stanza so that it defines only the
+// repository repo. Fields that already match are left untouched.
+func (p *deb822Paragraph) setRepository(repo *Repository) {
+	p.setList("Types", []string{repo.sourceType()})
+	p.setList("URIs", []string{repo.URI})
+	p.setList("Suites", []string{repo.Distribution})
+	if strings.TrimSpace(repo.Components) == "" {
+		p.del("Components")
+	} else {
+		p.setList("Components", strings.Fields(repo.Components))
+	}
+	if parseDeb822Bool(p.get("Enabled"), true) != repo.Enabled {
+		// The stanzas are enabled by default
+		if repo.Enabled {
+			p.del("Enabled")
+		} else {
+			p.set("Enabled", "no")
+		}
+	}
+	p.setOptions(repo.Options)
+}
+
+// setList changes the value of a list field, the field is left untouched
+// if it already contains the same values.
+func (p *deb822Paragraph) setList(name string, values []string) {
+	if slices.Equal(p.getList(name), values) {
+		return
+	}
+	p.set(name, strings.Join(values, " "))
+}
+
+// contains returns true if the stanza defines the repository repo
+func (p *deb822Paragraph) contains(repo *Repository) bool {
+	return p.repositories().Contains(repo)
+}
+
+// without removes the repository repo from the stanza. Since a stanza
+// defines all the combinations of Types, URIs and Suites, the remaining
+// repositories may require up to three stanzas: the first is the
+// original stanza modified in place, the others are returned and must
+// be added to the file right after it.
+func (p *deb822Paragraph) without(repo *Repository) (remove bool, added []*deb822Paragraph) {
+	types := p.getList("Types")
+	uris := p.getList("URIs")
+	suites := p.getList("Suites")
+
+	otherTypes := slices.DeleteFunc(slices.Clone(types), func(t string) bool { return t == repo.sourceType() })
+	otherURIs := slices.DeleteFunc(slices.Clone(uris), func(u string) bool { return u == repo.URI })
+	otherSuites := slices.DeleteFunc(slices.Clone(suites), func(s string) bool { return s == repo.Distribution })
+
+	// Each stanza is defined by the values of Types, URIs and Suites
+	parts := [][3][]string{}
+	if len(otherTypes) > 0 {
+		parts = append(parts, [3][]string{otherTypes, uris, suites})
+	}
+	if len(otherURIs) > 0 {
+		parts = append(parts, [3][]string{{repo.sourceType()}, otherURIs, suites})
+	}
+	if len(otherSuites) > 0 {
+		parts = append(parts, [3][]string{{repo.sourceType()}, {repo.URI}, otherSuites})
+	}
+	if len(parts) == 0 {
+		return true, nil
+	}
+	for i, part := range parts {
+		stanza := p
+		if i > 0 {
+			stanza = p.clone()
+			added = append(added, stanza)
+		}
+		stanza.setList("Types", part[0])
+		stanza.setList("URIs", part[1])
+		stanza.setList("Suites", part[2])
+	}
+	return false, added
+}
+
+// replace changes the definition of the repository old in the stanza
+// with the new one. It returns the stanzas that must be added right
+// after the modified one.
+func (p *deb822Paragraph) replace(old, newRepo *Repository) []*deb822Paragraph {
+	repos := p.repositories()
+	if len(repos) == 1 {
+		p.setRepository(newRepo)
+		return nil
+	}
+
+	// If only one of Types, URIs or Suites changes, and the other
+	// two are single valued, the value can be replaced in place.
+	types := p.getList("Types")
+	uris := p.getList("URIs")
+	suites := p.getList("Suites")
+	same := *old
+	same.SourceRepo = newRepo.SourceRepo
+	same.URI = newRepo.URI
+	same.Distribution = newRepo.Distribution
+	if same.Equals(newRepo) && same.Enabled == newRepo.Enabled {
+		switch {
+		case len(uris) == 1 && len(suites) == 1 && old.URI == newRepo.URI && old.Distribution == newRepo.Distribution:
+			p.setList("Types", replaceValue(types, old.sourceType(), newRepo.sourceType()))
+			return nil
+		case len(types) == 1 && len(suites) == 1 && old.SourceRepo == newRepo.SourceRepo && old.Distribution == newRepo.Distribution:
+			p.setList("URIs", replaceValue(uris, old.URI, newRepo.URI))
+			return nil
+		case len(types) == 1 && len(uris) == 1 && old.SourceRepo == newRepo.SourceRepo && old.URI == newRepo.URI:
+			p.setList("Suites", replaceValue(suites, old.Distribution, newRepo.Distribution))
+			return nil
+		}
+	}
+
+	// Otherwise split the stanza and add a new one for the new repository
+	stanza := p.clone()
+	stanza.setRepository(newRepo)
+	_, added := p.without(old)
+	return append(added, stanza)
+}
+
+func replaceValue(values []string, old, newValue string) []string {
+	res := []string{}
+	for _, v := range values {
+		if v == old {
+			v = newValue
+		}
+		if !slices.Contains(res, v) {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// newDeb822Stanza creates a new stanza that defines the repository repo
+func newDeb822Stanza(repo *Repository) *deb822Paragraph {
+	p := &deb822Paragraph{}
+	p.setRepository(repo)
+	return p
+}
+
+// sourcesFile is a deb822-style source file (the "*.sources" files in
+// "sources.list.d").
+type sourcesFile struct {
+	*deb822File
+	// original are the stanzas of the file before any change, so that
+	// the positions of the repositories remain valid
+	original []*deb822Paragraph
+	// split are, for each original stanza, the stanzas added right after
+	// it while changing its repositories
+	split map[*deb822Paragraph][]*deb822Paragraph
+	// order are the original values of Types, URIs and Suites of each
+	// original stanza, used to merge the split stanzas
+	order map[*deb822Paragraph][][]string
+}
+
+func newSourcesFile(data []byte) *sourcesFile {
+	f := parseDeb822(data)
+	res := &sourcesFile{deb822File: f, original: f.stanzas(), split: map[*deb822Paragraph][]*deb822Paragraph{}, order: map[*deb822Paragraph][][]string{}}
+	for _, p := range res.original {
+		res.order[p] = p.lists()
+	}
+	return res
+}
+
+// stanzaKeys are the fields whose combinations define the repositories
+// of a stanza
+var stanzaKeys = []string{"Types", "URIs", "Suites"}
+
+// otherFields returns the fields of the stanza, besides Types, URIs and
+// Suites, in a comparable form
+func (p *deb822Paragraph) otherFields() []string {
+	res := []string{}
+	for _, item := range p.items {
+		if item.name == "" || slices.ContainsFunc(stanzaKeys, func(k string) bool { return strings.EqualFold(k, item.name) }) {
+			continue
+		}
+		res = append(res, strings.ToLower(item.name)+": "+item.value())
+	}
+	slices.Sort(res)
+	return res
+}
+
+// mergeWith adds to the stanza the repositories of the stanza other, if
+// the result can still be defined by a single stanza: the two stanzas
+// must differ only in one of Types, URIs and Suites. The merged values
+// follow the order of the values in the lists of order.
+func (p *deb822Paragraph) mergeWith(other *deb822Paragraph, order [][]string) bool {
+	if !slices.Equal(p.otherFields(), other.otherFields()) {
+		return false
+	}
+	diff := -1
+	for i, name := range stanzaKeys {
+		if !sameSet(p.getList(name), other.getList(name)) {
+			if diff != -1 {
+				return false
+			}
+			diff = i
+		}
+	}
+	if diff == -1 {
+		return true
+	}
+	values := p.getList(stanzaKeys[diff])
+	for _, v := range other.getList(stanzaKeys[diff]) {
+		if !slices.Contains(values, v) {
+			values = append(values, v)
+		}
+	}
+	rank := func(v string) int {
+		if i := slices.Index(order[diff], v); i != -1 {
+			return i
+		}
+		return len(order[diff])
+	}
+	slices.SortStableFunc(values, func(a, b string) int { return rank(a) - rank(b) })
+	p.setList(stanzaKeys[diff], values)
+	return true
+}
+
+// merge joins again, where possible, the stanzas split from the original
+// stanza by the changes of its repositories
+func (f *sourcesFile) merge(original *deb822Paragraph) {
+	for merged := true; merged; {
+		merged = false
+		group := []*deb822Paragraph{}
+		for _, p := range f.paragraphs {
+			if p == original || slices.Contains(f.split[original], p) {
+				group = append(group, p)
+			}
+		}
+	search:
+		for i, p := range group {
+			for _, other := range group[i+1:] {
+				if p.mergeWith(other, f.order[original]) {
+					f.deb822File.remove(other)
+					merged = true
+					break search
+				}
+			}
+		}
+	}
+}
+
+func (f *sourcesFile) repositories() RepositoryList {
+	res := RepositoryList{}
+	line := 1
+	stanza := 0
+	for _, p := range f.paragraphs {
+		if !p.separator && p.lastField() != nil {
+			stanza++
+			for _, repo := range p.repositories() {
+				repo.Line = line
+				repo.Stanza = stanza
+				repo.Raw = redactText(p.text())
+				res = append(res, repo)
+			}
+		}
+		for _, item := range p.items {
+			line += len(item.lines)
+		}
+	}
+	return res
+}
+
+// entry returns the stanza at the position of the repository that
+// defines the repository, and the original stanza at that position. If
+// the original stanza has been split by previous changes, the repository
+// is searched also in the stanzas split from it.
+func (f *sourcesFile) entry(repo *Repository) (stanza, original *deb822Paragraph) {
+	if repo.Stanza < 1 || repo.Stanza > len(f.original) {
+		return nil, nil
+	}
+	original = f.original[repo.Stanza-1]
+	for _, p := range append([]*deb822Paragraph{original}, f.split[original]...) {
+		if slices.Contains(f.paragraphs, p) && p.contains(repo) {
+			return p, original
+		}
+	}
+	return nil, nil
+}
+
+func (f *sourcesFile) add(repo *Repository) {
+	f.append(newDeb822Stanza(repo))
+}
+
+func (f *sourcesFile) remove(repo *Repository) bool {
+	stanza, original := f.entry(repo)
+	if stanza == nil {
+		return false
+	}
+	if remove, added := stanza.without(repo); remove {
+		f.deb822File.remove(stanza)
+	} else {
+		f.insertAfter(stanza, added...)
+		f.split[original] = append(f.split[original], added...)
+	}
+	f.merge(original)
+	return true
+}
+
+func (f *sourcesFile) replace(old, newRepo *Repository) bool {
+	stanza, original := f.entry(old)
+	if stanza == nil {
+		return false
+	}
+	added := stanza.replace(old, newRepo)
+	f.insertAfter(stanza, added...)
+	f.split[original] = append(f.split[original], added...)
+	f.merge(original)
+	return true
+}
+
+// lists returns the values of Types, URIs and Suites
+func (p *deb822Paragraph) lists() [][]string {
+	res := [][]string{}
+	for _, name := range stanzaKeys {
+		res = append(res, p.getList(name))
+	}
+	return res
+}
+
+func (f *sourcesFile) diagnostics() []*Diagnostic {
+	res := []*Diagnostic{}
+	line := 1
+	for _, p := range f.paragraphs {
+		if !p.separator && p.lastField() != nil {
+			res = append(res, p.diagnostics(line)...)
+		}
+		for _, item := range p.items {
+			line += len(item.lines)
+		}
+	}
+	return res
+}
+
+func (f *sourcesFile) bytes() []byte {
+	return f.Bytes()
+}
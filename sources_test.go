@@ -0,0 +1,260 @@
+//
+//  This file is part of go-apt-client library
+//
+//  Copyright (C) 2017  Arduino AG (http://www.arduino.cc/)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+//
+
+package apt
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func setupDeb822ConfigFolder(t *testing.T) string {
+	folder := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(folder, "sources.list.d"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(folder, "sources.list"), nil, 0644))
+	data, err := os.ReadFile("testdata/apt/sources.list.d/ubuntu.sources")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(folder, "sources.list.d", "ubuntu.sources"), data, 0644))
+	return folder
+}
+
+func readSourcesFile(t *testing.T, folder string) string {
+	data, err := os.ReadFile(filepath.Join(folder, "sources.list.d", "ubuntu.sources"))
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestDeb822RoundTrip(t *testing.T) {
+	data, err := os.ReadFile("testdata/apt/sources.list.d/ubuntu.sources")
+	require.NoError(t, err)
+	require.Equal(t, string(data), string(parseDeb822(data).Bytes()))
+
+	data = []byte("# comment\r\nTypes: deb\r\nURIs: http://example.com\r\nSuites: stable\r\nComponents: main\r\n\r\n\r\nTypes: deb")
+	require.Equal(t, string(data), string(parseDeb822(data).Bytes()))
+}
+
+func TestRemoveDeb822Repository(t *testing.T) {
+	folder := setupDeb822ConfigFolder(t)
+
+	backports := &Repository{
+		Enabled:      true,
+		Options:      MustParseRepositoryOptions("signed-by=/usr/share/keyrings/ubuntu-archive-keyring.gpg"),
+		URI:          "http://archive.ubuntu.com/ubuntu/",
+		Distribution: "noble-backports",
+		Components:   "main restricted universe multiverse",
+	}
+	require.NoError(t, RemoveRepository(backports, folder))
+	require.Equal(t, `## Ubuntu distribution repository
+##
+## The following settings can be adjusted to configure which packages to use from Ubuntu.
+## See the sources.list(5) manual page for details.
+Types: deb
+URIs: http://archive.ubuntu.com/ubuntu/
+Suites: noble noble-updates
+Components: main restricted universe multiverse
+Signed-By: /usr/share/keyrings/ubuntu-archive-keyring.gpg
+
+## Ubuntu security updates. Aside from URIs and Suites,
+## this should mirror your choices in the previous stanza.
+Types: deb deb-src
+URIs: http://security.ubuntu.com/ubuntu/
+Suites: noble-security
+Components: main restricted universe multiverse
+Signed-By: /usr/share/keyrings/ubuntu-archive-keyring.gpg
+Enabled: no
+`, readSourcesFile(t, folder))
+
+	security := &Repository{
+		SourceRepo:   true,
+		Options:      MustParseRepositoryOptions("signed-by=/usr/share/keyrings/ubuntu-archive-keyring.gpg"),
+		URI:          "http://security.ubuntu.com/ubuntu/",
+		Distribution: "noble-security",
+		Components:   "main restricted universe multiverse",
+	}
+	require.NoError(t, RemoveRepository(security, folder))
+	security.SourceRepo = false
+	require.NoError(t, RemoveRepository(security, folder))
+	require.Equal(t, `## Ubuntu distribution repository
+##
+## The following settings can be adjusted to configure which packages to use from Ubuntu.
+## See the sources.list(5) manual page for details.
+Types: deb
+URIs: http://archive.ubuntu.com/ubuntu/
+Suites: noble noble-updates
+Components: main restricted universe multiverse
+Signed-By: /usr/share/keyrings/ubuntu-archive-keyring.gpg
+`, readSourcesFile(t, folder))
+
+	require.Error(t, RemoveRepository(security, folder))
+}
+
+func TestEditDeb822Repository(t *testing.T) {
+	folder := setupDeb822ConfigFolder(t)
+
+	updates := &Repository{
+		Enabled:      true,
+		Options:      MustParseRepositoryOptions("signed-by=/usr/share/keyrings/ubuntu-archive-keyring.gpg"),
+		URI:          "http://archive.ubuntu.com/ubuntu/",
+		Distribution: "noble-updates",
+		Components:   "main restricted universe multiverse",
+	}
+	proposed := *updates
+	proposed.Distribution = "noble-proposed"
+	require.NoError(t, EditRepository(updates, &proposed, folder))
+
+	securitySrc := &Repository{
+		SourceRepo:   true,
+		Options:      MustParseRepositoryOptions("signed-by=/usr/share/keyrings/ubuntu-archive-keyring.gpg"),
+		URI:          "http://security.ubuntu.com/ubuntu/",
+		Distribution: "noble-security",
+		Components:   "main restricted universe multiverse",
+	}
+	enabledSrc := *securitySrc
+	enabledSrc.Enabled = true
+	enabledSrc.Components = "main"
+	require.NoError(t, EditRepository(securitySrc, &enabledSrc, folder))
+	require.Equal(t, `## Ubuntu distribution repository
+##
+## The following settings can be adjusted to configure which packages to use from Ubuntu.
+## See the sources.list(5) manual page for details.
+Types: deb
+URIs: http://archive.ubuntu.com/ubuntu/
+Suites: noble noble-proposed noble-backports
+Components: main restricted universe multiverse
+Signed-By: /usr/share/keyrings/ubuntu-archive-keyring.gpg
+
+## Ubuntu security updates. Aside from URIs and Suites,
+## this should mirror your choices in the previous stanza.
+Types: deb
+URIs: http://security.ubuntu.com/ubuntu/
+Suites: noble-security
+Components: main restricted universe multiverse
+Signed-By: /usr/share/keyrings/ubuntu-archive-keyring.gpg
+Enabled: no
+
+Types: deb-src
+URIs: http://security.ubuntu.com/ubuntu/
+Suites: noble-security
+Components: main
+Signed-By: /usr/share/keyrings/ubuntu-archive-keyring.gpg
+`, readSourcesFile(t, folder))
+
+	repos, err := ParseAPTConfigFolder(folder)
+	require.NoError(t, err)
+	require.True(t, repos.Contains(&proposed))
+	require.False(t, repos.Contains(updates))
+	require.True(t, repos.Contains(&enabledSrc))
+	require.False(t, repos.Contains(securitySrc))
+}
+
+func TestAddDeb822Repository(t *testing.T) {
+	folder := setupDeb822ConfigFolder(t)
+	managed := filepath.Join(folder, "sources.list.d", "managed.sources")
+	require.NoError(t, os.WriteFile(managed, []byte("# Managed repositories\n"), 0644))
+
+	repo := &Repository{
+		Enabled:      true,
+		Options:      MustParseRepositoryOptions("arch=amd64,arm64 signed-by=/etc/apt/keyrings/example.gpg"),
+		URI:          "https://example.com/debian",
+		Distribution: "stable",
+		Components:   "main",
+	}
+	require.NoError(t, AddRepository(repo, folder))
+	require.Error(t, AddRepository(repo, folder))
+
+	data, err := os.ReadFile(managed)
+	require.NoError(t, err)
+	require.Equal(t, `# Managed repositories
+
+Types: deb
+URIs: https://example.com/debian
+Suites: stable
+Components: main
+Architectures: amd64 arm64
+Signed-By: /etc/apt/keyrings/example.gpg
+`, string(data))
+
+	repos, err := ParseAPTConfigFolder(folder)
+	require.NoError(t, err)
+	require.True(t, repos.Contains(repo))
+}
+
+func TestFlatDeb822Repository(t *testing.T) {
+	folder := setupDeb822ConfigFolder(t)
+	managed := filepath.Join(folder, "sources.list.d", "managed.sources")
+	require.NoError(t, os.WriteFile(managed, nil, 0644))
+	flat := &Repository{Enabled: true, URI: "https://example.com/repo", Distribution: "./"}
+	require.NoError(t, AddRepository(flat, folder))
+	data, err := os.ReadFile(managed)
+	require.NoError(t, err)
+	require.Equal(t, "Types: deb\nURIs: https://example.com/repo\nSuites: ./\n", string(data))
+
+	repos, diags, err := ParseAPTConfigFolderWithDiagnostics(folder, ParseStrict)
+	require.NoError(t, err)
+	require.Empty(t, diags)
+	require.True(t, repos.Contains(flat))
+}
+
+func TestEnableDisableDeb822Repository(t *testing.T) {
+	folder := setupDeb822ConfigFolder(t)
+	repos, err := ParseAPTConfigFolder(folder)
+	require.NoError(t, err)
+	require.Len(t, repos, 5)
+
+	// Enabling one of the repositories of the disabled stanza splits it
+	require.NoError(t, EnableRepository(repos[3], folder))
+	security := `## Ubuntu security updates. Aside from URIs and Suites,
+## this should mirror your choices in the previous stanza.
+Types: deb-src
+URIs: http://security.ubuntu.com/ubuntu/
+Suites: noble-security
+Components: main restricted universe multiverse
+Signed-By: /usr/share/keyrings/ubuntu-archive-keyring.gpg
+Enabled: no
+
+Types: deb
+URIs: http://security.ubuntu.com/ubuntu/
+Suites: noble-security
+Components: main restricted universe multiverse
+Signed-By: /usr/share/keyrings/ubuntu-archive-keyring.gpg
+`
+	require.True(t, strings.HasSuffix(readSourcesFile(t, folder), security))
+
+	// The "Enabled: no" field is removed
+	repos, err = ParseAPTConfigFolder(folder)
+	require.NoError(t, err)
+	require.True(t, repos[4].Enabled)
+	require.False(t, repos[3].Enabled)
+	require.NoError(t, EnableRepository(repos[3], folder))
+	security = strings.Replace(security, "Enabled: no\n", "", 1)
+	require.True(t, strings.HasSuffix(readSourcesFile(t, folder), security))
+
+	// And added back
+	repos, err = ParseAPTConfigFolder(folder)
+	require.NoError(t, err)
+	require.NoError(t, DisableRepository(repos[4], folder))
+	require.True(t, strings.HasSuffix(readSourcesFile(t, folder), security+"Enabled: no\n"))
+	repos, err = ParseAPTConfigFolder(folder)
+	require.NoError(t, err)
+	require.True(t, repos[3].Enabled)
+	require.False(t, repos[4].Enabled)
+}
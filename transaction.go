@@ -0,0 +1,391 @@
+//
+//  This file is part of go-apt-client library
+//
+//  Copyright (C) 2017  Arduino AG (http://www.arduino.cc/)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+//
+
+package apt
+
+import (
+	"cmp"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+type txOpKind int
+
+const (
+	txAdd txOpKind = iota
+	txEdit
+	txRemove
+	txEnable
+	txDisable
+)
+
+// txOp is a change collected by a Transaction
+type txOp struct {
+	kind txOpKind
+	// repo is the repository to add, edit or remove
+	repo *Repository
+	// newRepo is the new version of the edited repository
+	newRepo *Repository
+	// entry is the configured repository that is changed
+	entry *Repository
+}
+
+// Transaction collects additions, edits and removals of repositories,
+// possibly defined in different files of the same APT config folder,
+// that are applied together by Commit: either all the changes are
+// written or none of them is.
+type Transaction struct {
+	configFolderPath string
+	c                *config
+	ops              []*txOp
+	// credentials are the auth entries to set, besides the ones moved
+	// from the URIs of the repositories
+	credentials []*AuthEntry
+}
+
+// NewTransaction starts a new transaction on the specified APT config
+// folder (usually /etc/apt).
+func NewTransaction(configFolderPath string, opts ...Option) *Transaction {
+	return newTransaction(configFolderPath, newConfig(opts))
+}
+
+func newTransaction(configFolderPath string, c *config) *Transaction {
+	return &Transaction{configFolderPath: configFolderPath, c: c}
+}
+
+// WithTargetFile selects the file of the "sources.list.d" folder where
+// the new repositories are added, instead of "managed.list" or
+// "managed.sources". The name must end with ".list", for the one-line
+// format, or ".sources", for the deb822 format. The file is created if
+// it doesn't exist.
+func WithTargetFile(name string) Option {
+	return func(c *config) {
+		c.targetFile = name
+	}
+}
+
+// WithFileHeader sets a comment that is written at the beginning of the
+// source files created to add new repositories, one "#" comment line for
+// each line of text.
+func WithFileHeader(text string) Option {
+	return func(c *config) {
+		c.fileHeader = text
+	}
+}
+
+// WithOverlapCheck makes the additions of repositories fail if they
+// overlap with a configured repository (see Repository.Overlaps), for
+// example if the same URI and distribution are already configured with
+// some of the same components.
+func WithOverlapCheck() Option {
+	return func(c *config) {
+		c.overlapCheck = true
+	}
+}
+
+// AddRepository adds the repository, like the AddRepository function,
+// when the transaction is committed
+func (t *Transaction) AddRepository(repo *Repository) {
+	t.ops = append(t.ops, &txOp{kind: txAdd, repo: repo})
+}
+
+// EditRepository replaces the old repository configuration with newRepo,
+// like the EditRepository function, when the transaction is committed
+func (t *Transaction) EditRepository(old *Repository, newRepo *Repository) {
+	t.ops = append(t.ops, &txOp{kind: txEdit, repo: old, newRepo: newRepo})
+}
+
+// RemoveRepository removes the repository, like the RemoveRepository
+// function, when the transaction is committed
+func (t *Transaction) RemoveRepository(repo *Repository) {
+	t.ops = append(t.ops, &txOp{kind: txRemove, repo: repo})
+}
+
+// EnableRepository enables the repository, like the EnableRepository
+// function, when the transaction is committed
+func (t *Transaction) EnableRepository(repo *Repository) {
+	t.ops = append(t.ops, &txOp{kind: txEnable, repo: repo})
+}
+
+// DisableRepository disables the repository, like the DisableRepository
+// function, when the transaction is committed
+func (t *Transaction) DisableRepository(repo *Repository) {
+	t.ops = append(t.ops, &txOp{kind: txDisable, repo: repo})
+}
+
+// Commit checks all the collected changes and, if they are valid, writes
+// all the changed files while holding the lock of the APT config folder.
+// The edited and removed repositories are searched between the ones
+// configured before the transaction. If any file can't be written, the
+// files already changed are restored to their previous version.
+func (t *Transaction) Commit() error {
+	unlock, err := t.c.lock(t.configFolderPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return t.commit()
+}
+
+func (t *Transaction) commit() error {
+	fsys, err := t.c.writableFS()
+	if err != nil {
+		return err
+	}
+	changes, err := t.apply(fsys)
+	if err != nil {
+		return err
+	}
+	if err := writeSecretFiles(fsys, changes, t.c.backups); err != nil {
+		return fmt.Errorf("writing of new config: %s", err)
+	}
+
+	// Delete the keyrings that are no longer in use
+	keyrings := []string{}
+	for _, op := range t.ops {
+		if op.kind == txRemove {
+			keyrings = append(keyrings, op.entry.Options.SignedBy...)
+		}
+	}
+	return removeUnusedKeyrings(keyrings, t.configFolderPath, t.c)
+}
+
+// fileChange is the change of a source file made by a transaction
+type fileChange struct {
+	path    string
+	content []byte
+	// deleted is true if the file must be removed
+	deleted bool
+	// secret is true for the auth files with credentials, that are
+	// created readable only by the owner and redacted in the previews
+	secret bool
+}
+
+// apply validates the collected changes and returns the changed files
+// with their new content
+func (t *Transaction) apply(fsys fs.FS) ([]*fileChange, error) {
+	repos, _, err := parseAPTConfigFolder(t.configFolderPath, ParseLenient, t.c)
+	if err != nil {
+		return nil, fmt.Errorf("parsing APT config: %s", err)
+	}
+
+	paths := []string{}
+	files := map[string]sourceFile{}
+	// file loads the source file, a missing file starts with the given header
+	file := func(path string, header string) (sourceFile, error) {
+		if f, ok := files[path]; ok {
+			return f, nil
+		}
+		data, err := fs.ReadFile(fsys, path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("reading config file %s: %s", path, err)
+		}
+		if os.IsNotExist(err) {
+			data = []byte(header)
+		}
+		f := parseSourceFile(path, data)
+		paths = append(paths, path)
+		files[path] = f
+		return f, nil
+	}
+
+	// Check the changes and find the entries to change
+	added := RepositoryList{}
+	changes := []*txOp{}
+	credentials := slices.Clone(t.credentials)
+	// withoutCredentials returns the repository with the credentials of
+	// the URI moved to the auth files
+	withoutCredentials := func(repo *Repository) (*Repository, error) {
+		uri, entry := splitCredentials(repo.URI)
+		if entry == nil {
+			return repo, nil
+		}
+		if err := entry.validate(); err != nil {
+			return nil, fmt.Errorf("invalid credentials: %s", err)
+		}
+		credentials = append(credentials, entry)
+		res := *repo
+		res.URI = uri
+		return &res, nil
+	}
+	for _, op := range t.ops {
+		switch op.kind {
+		case txAdd:
+			repo, err := withoutCredentials(op.repo)
+			if err != nil {
+				return nil, err
+			}
+			if err := repo.validate(); err != nil {
+				return nil, fmt.Errorf("invalid repository: %s", err)
+			}
+			if repos.Contains(repo) || added.Contains(repo) {
+				return nil, fmt.Errorf("the repository is already configured")
+			}
+			if t.c.overlapCheck {
+				if overlaps := repos.Overlapping(repo); len(overlaps) > 0 {
+					return nil, fmt.Errorf("the repository overlaps with the one at %s:%d", overlaps[0].File, overlaps[0].Line)
+				}
+				if len(added.Overlapping(repo)) > 0 {
+					return nil, fmt.Errorf("the repository overlaps with another added repository")
+				}
+			}
+			added = append(added, repo)
+		case txEdit:
+			newRepo, err := withoutCredentials(op.newRepo)
+			if err != nil {
+				return nil, err
+			}
+			if err := newRepo.validate(); err != nil {
+				return nil, fmt.Errorf("invalid repository: %s", err)
+			}
+			if op.entry = repos.findEntry(op.repo); op.entry == nil {
+				return nil, fmt.Errorf("repository doesn't exist")
+			}
+			edit := *op
+			edit.newRepo = newRepo
+			changes = append(changes, &edit)
+		case txRemove:
+			if op.entry = repos.findEntry(op.repo); op.entry == nil {
+				return nil, fmt.Errorf("repository already removed")
+			}
+			changes = append(changes, op)
+		case txEnable, txDisable:
+			if op.entry = repos.findEntry(op.repo); op.entry == nil {
+				return nil, fmt.Errorf("repository doesn't exist")
+			}
+			enabled := op.kind == txEnable
+			if op.entry.Enabled == enabled {
+				continue
+			}
+			newRepo := *op.entry
+			newRepo.Enabled = enabled
+			op.newRepo = &newRepo
+			changes = append(changes, op)
+		}
+	}
+
+	// Change the entries starting from the end of each file, so that the
+	// position of the entries still to be changed remains valid
+	slices.SortStableFunc(changes, func(a, b *txOp) int {
+		return cmp.Or(
+			cmp.Compare(a.entry.File, b.entry.File),
+			cmp.Compare(b.entry.Line, a.entry.Line),
+			cmp.Compare(b.entry.Stanza, a.entry.Stanza))
+	})
+	for _, op := range changes {
+		f, err := file(op.entry.File, "")
+		if err != nil {
+			return nil, err
+		}
+		if op.kind != txRemove && !isDeb822File(op.entry.File) {
+			if err := op.newRepo.Options.validateOneLine(); err != nil {
+				return nil, fmt.Errorf("invalid repository: %s", err)
+			}
+		}
+		if op.kind != txRemove && !f.replace(op.entry, op.newRepo) {
+			return nil, fmt.Errorf("repository doesn't exist")
+		}
+		if op.kind == txRemove && !f.remove(op.entry) {
+			return nil, fmt.Errorf("repository already removed")
+		}
+	}
+
+	if len(added) > 0 {
+		target, err := t.targetPath(fsys)
+		if err != nil {
+			return nil, err
+		}
+		f, err := file(target, formatFileHeader(t.c.fileHeader))
+		if err != nil {
+			return nil, err
+		}
+		for _, repo := range added {
+			if isDeb822File(target) {
+				break
+			}
+			if err := repo.Options.validateOneLine(); err != nil {
+				return nil, fmt.Errorf("invalid repository: %s", err)
+			}
+		}
+		for _, repo := range added {
+			f.add(repo)
+		}
+	}
+
+	res := []*fileChange{}
+	sourcesFolder := filepath.Join(t.configFolderPath, "sources.list.d")
+	for _, path := range paths {
+		f := files[path]
+		change := &fileChange{path: path, content: f.bytes()}
+		// The files in "sources.list.d" are deleted once their last
+		// entry is removed
+		if filepath.Dir(path) == sourcesFolder && len(f.repositories()) == 0 && len(f.diagnostics()) == 0 {
+			change.deleted = slices.ContainsFunc(changes, func(op *txOp) bool {
+				return op.kind == txRemove && op.entry.File == path
+			})
+		}
+		res = append(res, change)
+	}
+
+	if len(credentials) > 0 {
+		authChanges, _, err := changeAuthEntries(fsys, t.configFolderPath, credentials, nil)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, authChanges...)
+	}
+	return res, nil
+}
+
+// sourceFileNameRegexp matches the names of the files in "sources.list.d"
+// that are not ignored by apt
+var sourceFileNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]+\.(list|sources)$`)
+
+// targetPath returns the file where the new repositories are added: the
+// one selected with WithTargetFile or, by default, "managed.sources" if
+// present, otherwise "managed.list"
+func (t *Transaction) targetPath(fsys fs.FS) (string, error) {
+	if t.c.targetFile != "" {
+		if !sourceFileNameRegexp.MatchString(t.c.targetFile) {
+			return "", fmt.Errorf("invalid target file name '%s'", t.c.targetFile)
+		}
+		return filepath.Join(t.configFolderPath, "sources.list.d", t.c.targetFile), nil
+	}
+	managedPath := filepath.Join(t.configFolderPath, "sources.list.d", "managed.sources")
+	if _, err := fs.Stat(fsys, managedPath); err != nil {
+		managedPath = filepath.Join(t.configFolderPath, "sources.list.d", "managed.list")
+	}
+	return managedPath, nil
+}
+
+// formatFileHeader returns the header text as comment lines
+func formatFileHeader(header string) string {
+	if header == "" {
+		return ""
+	}
+	res := ""
+	for _, line := range strings.Split(strings.TrimRight(header, "\n"), "\n") {
+		res += strings.TrimRight("# "+line, " ") + "\n"
+	}
+	return res
+}
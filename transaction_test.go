@@ -0,0 +1,270 @@
+//
+//  This file is part of go-apt-client library
+//
+//  Copyright (C) 2017  Arduino AG (http://www.arduino.cc/)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+//
+
+package apt
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// failingFS is a MemFS that fails to rename the given file
+type failingFS struct {
+	*MemFS
+	failRename string
+}
+
+func (f *failingFS) Rename(oldname, newname string) error {
+	if oldname == f.failRename {
+		return fmt.Errorf("injected failure")
+	}
+	return f.MemFS.Rename(oldname, newname)
+}
+
+const (
+	txSourcesList = "deb http://example.com/debian stable main\n" +
+		"deb http://example.com/debian testing main\n" +
+		"deb http://example.com/debian unstable main\n"
+	txOtherList = "deb http://other.example.com/debian stable main\n"
+	txSources   = "Types: deb deb-src\n" +
+		"URIs: http://example.com/ubuntu\n" +
+		"Suites: noble\n" +
+		"Components: main\n"
+)
+
+func newTransactionTestFS() *MemFS {
+	return NewMemFS(map[string]string{
+		"etc/apt/sources.list":                  txSourcesList,
+		"etc/apt/sources.list.d/other.list":     txOtherList,
+		"etc/apt/sources.list.d/ubuntu.sources": txSources,
+	})
+}
+
+func readFSFile(t *testing.T, fsys fs.FS, name string) string {
+	data, err := fs.ReadFile(fsys, name)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestTransactionCommit(t *testing.T) {
+	fsys := newTransactionTestFS()
+	repos, err := ParseAPTConfigFolder("etc/apt", WithFS(fsys))
+	require.NoError(t, err)
+	require.Len(t, repos, 6)
+
+	mirror := func(repo *Repository, uri string) *Repository {
+		res := *repo
+		res.URI = uri
+		return &res
+	}
+	tx := NewTransaction("etc/apt", WithFS(fsys))
+	tx.RemoveRepository(repos[0])
+	tx.EditRepository(repos[2], mirror(repos[2], "http://mirror.example.com/debian"))
+	tx.EditRepository(repos[3], mirror(repos[3], "http://mirror.example.com/other"))
+	tx.EditRepository(repos[4], mirror(repos[4], "http://mirror.example.com/ubuntu"))
+	tx.EditRepository(repos[5], mirror(repos[5], "http://mirror.example.com/ubuntu"))
+	tx.AddRepository(&Repository{Enabled: true, URI: "http://new.example.com/debian", Distribution: "stable", Components: "main"})
+	require.NoError(t, tx.Commit())
+
+	require.Equal(t, "deb http://example.com/debian testing main\n"+
+		"deb http://mirror.example.com/debian unstable main\n", readFSFile(t, fsys, "etc/apt/sources.list"))
+	require.Equal(t, "deb http://mirror.example.com/other stable main\n", readFSFile(t, fsys, "etc/apt/sources.list.d/other.list"))
+	// The stanza split by the first change is joined again
+	require.Equal(t, "Types: deb deb-src\n"+
+		"URIs: http://mirror.example.com/ubuntu\n"+
+		"Suites: noble\n"+
+		"Components: main\n", readFSFile(t, fsys, "etc/apt/sources.list.d/ubuntu.sources"))
+	require.Equal(t, "deb http://new.example.com/debian stable main\n", readFSFile(t, fsys, "etc/apt/sources.list.d/managed.list"))
+	require.Equal(t, txSourcesList, readFSFile(t, fsys, "etc/apt/sources.list.save"))
+}
+
+func TestTransactionValidation(t *testing.T) {
+	fsys := newTransactionTestFS()
+	repos, err := ParseAPTConfigFolder("etc/apt", WithFS(fsys))
+	require.NoError(t, err)
+
+	tx := NewTransaction("etc/apt", WithFS(fsys))
+	tx.RemoveRepository(repos[0])
+	tx.AddRepository(&Repository{Enabled: true, URI: "http://new.example.com/debian", Distribution: "stable"})
+	require.EqualError(t, tx.Commit(), "invalid repository: missing components")
+
+	tx = NewTransaction("etc/apt", WithFS(fsys))
+	tx.RemoveRepository(repos[0])
+	tx.EditRepository(repos[0], repos[1])
+	require.EqualError(t, tx.Commit(), "repository doesn't exist")
+
+	tx = NewTransaction("etc/apt", WithFS(fsys))
+	tx.AddRepository(repos[3])
+	require.EqualError(t, tx.Commit(), "the repository is already configured")
+
+	// Nothing changed
+	require.Equal(t, txSourcesList, readFSFile(t, fsys, "etc/apt/sources.list"))
+	_, err = fs.Stat(fsys, "etc/apt/sources.list.save")
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestTransactionRollback(t *testing.T) {
+	fsys := &failingFS{MemFS: newTransactionTestFS(), failRename: "etc/apt/sources.list.d/managed.list.new"}
+	repos, err := ParseAPTConfigFolder("etc/apt", WithFS(fsys))
+	require.NoError(t, err)
+
+	tx := NewTransaction("etc/apt", WithFS(fsys))
+	tx.RemoveRepository(repos[0])
+	tx.RemoveRepository(repos[3])
+	tx.AddRepository(&Repository{Enabled: true, URI: "http://new.example.com/debian", Distribution: "stable", Components: "main"})
+	require.ErrorContains(t, tx.Commit(), "injected failure")
+
+	require.Equal(t, txSourcesList, readFSFile(t, fsys, "etc/apt/sources.list"))
+	require.Equal(t, txOtherList, readFSFile(t, fsys, "etc/apt/sources.list.d/other.list"))
+	entries, err := fs.ReadDir(fsys, "etc/apt/sources.list.d")
+	require.NoError(t, err)
+	names := []string{}
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	require.Equal(t, []string{"other.list", "ubuntu.sources"}, names)
+	_, err = fs.Stat(fsys, "etc/apt/sources.list.new")
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestTransactionTargetFile(t *testing.T) {
+	fsys := newTransactionTestFS()
+	repo := &Repository{Enabled: true, URI: "http://new.example.com/debian", Distribution: "stable", Components: "main"}
+	header := "Managed by the installer\n\nDo not edit"
+
+	require.NoError(t, AddRepository(repo, "etc/apt", WithFS(fsys), WithTargetFile("new.list"), WithFileHeader(header)))
+	require.Equal(t, "# Managed by the installer\n"+
+		"#\n"+
+		"# Do not edit\n"+
+		"deb http://new.example.com/debian stable main\n", readFSFile(t, fsys, "etc/apt/sources.list.d/new.list"))
+	info, err := fs.Stat(fsys, "etc/apt/sources.list.d/new.list")
+	require.NoError(t, err)
+	require.Equal(t, fs.FileMode(0644), info.Mode().Perm())
+
+	repo = &Repository{Enabled: true, URI: "http://new.example.com/debian", Distribution: "testing", Components: "main"}
+	require.NoError(t, AddRepository(repo, "etc/apt", WithFS(fsys), WithTargetFile("new.sources"), WithFileHeader(header)))
+	require.Equal(t, "# Managed by the installer\n"+
+		"#\n"+
+		"# Do not edit\n"+
+		"\n"+
+		"Types: deb\n"+
+		"URIs: http://new.example.com/debian\n"+
+		"Suites: testing\n"+
+		"Components: main\n", readFSFile(t, fsys, "etc/apt/sources.list.d/new.sources"))
+
+	// The header is written only in new files
+	other := &Repository{Enabled: true, URI: "http://other.example.com/debian", Distribution: "testing", Components: "main"}
+	require.NoError(t, AddRepository(other, "etc/apt", WithFS(fsys), WithTargetFile("other.list"), WithFileHeader(header)))
+	require.Equal(t, txOtherList+"deb http://other.example.com/debian testing main\n", readFSFile(t, fsys, "etc/apt/sources.list.d/other.list"))
+
+	for _, name := range []string{"new", "../new.list", "new.conf", "new list.list"} {
+		err := AddRepository(&Repository{Enabled: true, URI: "http://x.example.com/debian", Distribution: "stable", Components: "main"}, "etc/apt", WithFS(fsys), WithTargetFile(name))
+		require.EqualError(t, err, "invalid target file name '"+name+"'")
+	}
+}
+
+func TestRemoveLastRepositoryOfFile(t *testing.T) {
+	fsys := newTransactionTestFS()
+	repos, err := ParseAPTConfigFolder("etc/apt", WithFS(fsys))
+	require.NoError(t, err)
+
+	// The file is deleted, and kept as backup copy
+	require.NoError(t, RemoveRepository(repos[3], "etc/apt", WithFS(fsys)))
+	_, err = fs.Stat(fsys, "etc/apt/sources.list.d/other.list")
+	require.True(t, os.IsNotExist(err))
+	require.Equal(t, txOtherList, readFSFile(t, fsys, "etc/apt/sources.list.d/other.list.save"))
+
+	// Files with comments only are deleted too
+	other := &Repository{Enabled: true, URI: "http://other.example.com/debian", Distribution: "stable", Components: "main"}
+	require.NoError(t, fsys.WriteFile("etc/apt/sources.list.d/commented.list", []byte("# Comment\n"+txOtherList), 0644))
+	require.NoError(t, RemoveRepository(other, "etc/apt", WithFS(fsys)))
+	_, err = fs.Stat(fsys, "etc/apt/sources.list.d/commented.list")
+	require.True(t, os.IsNotExist(err))
+
+	require.NoError(t, RemoveRepository(repos[4], "etc/apt", WithFS(fsys)))
+	require.NoError(t, RemoveRepository(repos[5], "etc/apt", WithFS(fsys)))
+	_, err = fs.Stat(fsys, "etc/apt/sources.list.d/ubuntu.sources")
+	require.True(t, os.IsNotExist(err))
+
+	// sources.list is never deleted
+	tx := NewTransaction("etc/apt", WithFS(fsys))
+	for _, repo := range repos[:3] {
+		tx.RemoveRepository(repo)
+	}
+	require.NoError(t, tx.Commit())
+	require.Equal(t, "", readFSFile(t, fsys, "etc/apt/sources.list"))
+
+	// Disabled entries are kept
+	fsys = newTransactionTestFS()
+	require.NoError(t, fsys.WriteFile("etc/apt/sources.list.d/other.list", []byte("# deb http://disabled.example.com/debian stable main\n"+txOtherList), 0644))
+	require.NoError(t, RemoveRepository(other, "etc/apt", WithFS(fsys)))
+	require.Equal(t, "# deb http://disabled.example.com/debian stable main\n", readFSFile(t, fsys, "etc/apt/sources.list.d/other.list"))
+}
+
+func TestTransactionSameStanza(t *testing.T) {
+	fsys := NewMemFS(map[string]string{
+		"etc/apt/sources.list": "",
+		"etc/apt/sources.list.d/ubuntu.sources": "Types: deb deb-src\n" +
+			"URIs: http://archive.ubuntu.com/ubuntu\n" +
+			"Suites: noble noble-updates\n" +
+			"Components: main\n",
+	})
+	repos, err := ParseAPTConfigFolder("etc/apt", WithFS(fsys))
+	require.NoError(t, err)
+	require.Len(t, repos, 4)
+
+	// Every repository of the stanza is changed in the same transaction
+	tx := NewTransaction("etc/apt", WithFS(fsys))
+	expected := RepositoryList{}
+	for _, repo := range repos {
+		newRepo := *repo
+		newRepo.Distribution = strings.Replace(repo.Distribution, "noble", "plucky", 1)
+		tx.EditRepository(repo, &newRepo)
+		expected = append(expected, &newRepo)
+	}
+	require.NoError(t, tx.Commit())
+	require.Equal(t, "Types: deb deb-src\n"+
+		"URIs: http://archive.ubuntu.com/ubuntu\n"+
+		"Suites: plucky plucky-updates\n"+
+		"Components: main\n", readFSFile(t, fsys, "etc/apt/sources.list.d/ubuntu.sources"))
+	repos, err = ParseAPTConfigFolder("etc/apt", WithFS(fsys))
+	require.NoError(t, err)
+	require.Len(t, repos, 4)
+	for _, repo := range expected {
+		require.True(t, repos.Contains(repo), repo.String())
+	}
+
+	// Removals and edits of the stanzas split from the same one
+	tx = NewTransaction("etc/apt", WithFS(fsys))
+	tx.RemoveRepository(repos[0])
+	tx.RemoveRepository(repos[3])
+	newRepo := *repos[1]
+	newRepo.Enabled = false
+	tx.EditRepository(repos[1], &newRepo)
+	require.NoError(t, tx.Commit())
+	left, err := ParseAPTConfigFolder("etc/apt", WithFS(fsys))
+	require.NoError(t, err)
+	require.Len(t, left, 2)
+	require.True(t, left.Contains(repos[2]))
+	require.False(t, left.Find(repos[1]).Enabled)
+}